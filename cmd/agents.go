@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sshAgentArgs forwards the host ssh agent, keys never leave the host
+func sshAgentArgs() []string {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if !isSocket(socket) {
+		logger.Println("no ssh agent running (SSH_AUTH_SOCK), won't forward it")
+		return nil
+	}
+	logger.Printf("ssh agent socket: %s\n", socket)
+	const contSocket = socketsDir + "/ssh-agent.sock"
+	return []string{
+		fmt.Sprintf("--volume=%s:%s", socket, contSocket),
+		"--env=SSH_AUTH_SOCK=" + contSocket,
+	}
+}
+
+// gpgAgentArgs forwards the restricted (extra) socket of the host gpg
+// agent and the public keyring, gpg looks for the agent in the runtime
+// dir if there's one (see waylandArgs) or in ~/.gnupg
+func gpgAgentArgs(homeDir, runtimeDir string) []string {
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-extra-socket").Output()
+	if err != nil {
+		return nil
+	}
+	socket := strings.TrimSpace(string(out))
+	if !isSocket(socket) {
+		logger.Println("no gpg agent running, won't forward it")
+		return nil
+	}
+	logger.Printf("gpg agent extra socket: %s\n", socket)
+
+	gnupgDir := filepath.Join(homeDir, ".gnupg")
+	args := []string{fmt.Sprintf("--volume=%s:%s/S.gpg-agent", socket, gnupgDir)}
+	if runtimeDir != "" {
+		args = append(args, fmt.Sprintf("--volume=%s:%s/gnupg/S.gpg-agent", socket, runtimeDir))
+	}
+	for _, keyring := range []string{"pubring.kbx", "pubring.gpg", "trustdb.gpg"} {
+		path := filepath.Join(gnupgDir, keyring)
+		if _, err := os.Stat(path); err == nil {
+			args = append(args, fmt.Sprintf("--volume=%s:%s:ro", path, path))
+		}
+	}
+	return args
+}
+
+// gitConfigArgs shares the host git config read-only and passes the
+// git user.* settings (name, email, signingkey...) as environment, so
+// they are there even if they come from other files (e.g. an include)
+func gitConfigArgs(homeDir string) []string {
+	args := []string{}
+	if homeDir != "" {
+		for _, config := range []string{".gitconfig", ".config/git/config"} {
+			path := filepath.Join(homeDir, config)
+			if _, err := os.Stat(path); err == nil {
+				args = append(args, fmt.Sprintf("--volume=%s:%s:ro", path, path))
+			}
+		}
+	}
+
+	out, err := exec.Command("git", "config", "--get-regexp", `^user\.`).Output()
+	if err != nil {
+		return args
+	}
+	count := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		if key == "" {
+			continue
+		}
+		args = append(args,
+			fmt.Sprintf("--env=GIT_CONFIG_KEY_%d=%s", count, key),
+			fmt.Sprintf("--env=GIT_CONFIG_VALUE_%d=%s", count, value))
+		count++
+	}
+	if count > 0 {
+		logger.Printf("git user config: %d settings\n", count)
+		args = append(args, fmt.Sprintf("--env=GIT_CONFIG_COUNT=%d", count))
+	}
+	return args
+}
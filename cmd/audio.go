@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pulseSocket returns the host PulseAudio socket (also provided by
+// pipewire-pulse), or an empty string if not found
+func pulseSocket() string {
+	socket := ""
+	if server := os.Getenv("PULSE_SERVER"); strings.HasPrefix(server, "unix:") {
+		socket = strings.TrimPrefix(server, "unix:")
+	} else if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		socket = filepath.Join(runtimeDir, "pulse", "native")
+	}
+	if !isSocket(socket) {
+		return ""
+	}
+	return socket
+}
+
+// pulseCookie returns the host PulseAudio cookie file, or an empty
+// string if there's none (pipewire-pulse doesn't need it)
+func pulseCookie() string {
+	cookies := []string{os.Getenv("PULSE_COOKIE")}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		cookies = append(cookies, filepath.Join(configDir, "pulse", "cookie"))
+	}
+	cookies = append(cookies, filepath.Join(userSingleton().HomeDir, ".pulse-cookie"))
+	for _, cookie := range cookies {
+		if info, err := os.Stat(cookie); cookie != "" && err == nil && info.Mode().IsRegular() {
+			return cookie
+		}
+	}
+	return ""
+}
+
+// audioArgs mounts the PulseAudio and PipeWire sockets and
+// copies the PulseAudio cookie like the xauth one
+func audioArgs() []string {
+	args := []string{}
+	if socket := pulseSocket(); socket != "" {
+		logger.Printf("pulseaudio socket: %s\n", socket)
+		const contSocket = socketsDir + "/pulse/native"
+		args = append(args, "--volume="+socket+":"+contSocket,
+			"--env=PULSE_SERVER=unix:"+contSocket)
+		if cookie := pulseCookie(); cookie != "" {
+			logger.Printf("pulseaudio cookie: %s\n", cookie)
+			addCopyToContainerFile(cookie, "/.pulse-cookie")
+			args = append(args, "--env=PULSE_COOKIE=/.pulse-cookie")
+		}
+	} else {
+		logger.Println("WARNING: pulseaudio socket not found, is pulseaudio or pipewire-pulse running?")
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); isSocket(filepath.Join(runtimeDir, "pipewire-0")) {
+		logger.Printf("pipewire socket: %s\n", filepath.Join(runtimeDir, "pipewire-0"))
+		args = append(args,
+			"--volume="+filepath.Join(runtimeDir, "pipewire-0")+":"+socketsDir+"/pipewire-0",
+			"--env=PIPEWIRE_RUNTIME_DIR="+socketsDir)
+	}
+	return args
+}
@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Backend is the container runtime used by dogi, every operation on
+// images and containers goes through it instead of scraping cli output.
+//
+// Creating containers and attaching/executing interactively still go
+// through the runtime cli: their arguments are cli flags (including the
+// forwarded ones) and the cli takes care of the terminal.
+type Backend interface {
+	// Name is the runtime cli binary, e.g. docker
+	Name() string
+	Images() ([]imageSummary, error)
+	// Containers lists running containers, or all of them if all is set
+	Containers(all bool) ([]containerSummary, error)
+	Volumes() ([]volumeSummary, error)
+	InspectImage(name string) (imageInfo, error)
+	InspectContainer(name string) (containerInfo, error)
+	// ReadImageFile returns the content of a file inside an image,
+	// without running any container
+	ReadImageFile(image, path string) ([]byte, error)
+	// Create runs the cli create command and returns the container id
+	Create(args []string) (string, error)
+	// CopyTo copies srcpath (following symlinks and keeping the
+	// owner like docker cp -aL) to dstpath in the container
+	CopyTo(contId, srcpath, dstpath string) error
+	// ArchiveFrom streams srcpath of a container as a tar archive,
+	// ArchiveTo extracts a tar archive at dstpath of a container
+	ArchiveFrom(name, srcpath string) (io.ReadCloser, error)
+	ArchiveTo(name, dstpath string, archive io.Reader) error
+	// SaveImage streams an image as a tar archive, LoadImage loads it
+	SaveImage(name string) (io.ReadCloser, error)
+	LoadImage(archive io.Reader) error
+	// RunDetached creates and starts a background container
+	RunDetached(spec containerSpec) (string, error)
+	Stop(name string) error
+	Remove(name string) error
+	RemoveVolume(name string) error
+	// RemoveImage removes an image tag, and the image with its last one
+	RemoveImage(name string) error
+	InspectNetwork(name string) (networkInfo, error)
+	CreateNetwork(name string, labels map[string]string) error
+	// ConnectNetwork adds a container to one more network
+	ConnectNetwork(network, container string) error
+	// Exec runs a non interactive command in a running container
+	// and returns its output
+	Exec(name string, command ...string) ([]byte, error)
+	// StartAttachArgs and ExecArgs return the cli command line to
+	// attach to a created container or exec into a running one
+	StartAttachArgs(contId string) []string
+	ExecArgs(args []string) []string
+	Prune() (pruneReport, error)
+	// Build builds an image from a Dockerfile, files are the other
+	// context files as context path to host path. Canceling ctx stops
+	// the build.
+	Build(ctx context.Context, tag, dockerfile string, files, labels map[string]string) error
+}
+
+type imageSummary struct {
+	ID       string            `json:"Id"`
+	RepoTags []string          `json:"RepoTags"`
+	Created  int64             `json:"Created"`
+	Size     int64             `json:"Size"`
+	Labels   map[string]string `json:"Labels"`
+}
+
+type containerSummary struct {
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	Command string            `json:"Command"`
+	Created int64             `json:"Created"`
+	State   string            `json:"State"`
+	Status  string            `json:"Status"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+type volumeSummary struct {
+	Name       string            `json:"Name"`
+	Mountpoint string            `json:"Mountpoint"`
+	CreatedAt  string            `json:"CreatedAt"`
+	Labels     map[string]string `json:"Labels"`
+}
+
+type imageInfo struct {
+	ID     string `json:"Id"`
+	Config struct {
+		User       string            `json:"User"`
+		Cmd        []string          `json:"Cmd"`
+		Entrypoint []string          `json:"Entrypoint"`
+		Env        []string          `json:"Env"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+type containerInfo struct {
+	ID    string   `json:"Id"`
+	Name  string   `json:"Name"`
+	Image string   `json:"Image"`
+	Args  []string `json:"Args"`
+	State struct {
+		Status    string `json:"Status"`
+		Running   bool   `json:"Running"`
+		StartedAt string `json:"StartedAt"`
+	} `json:"State"`
+	// running exec sessions
+	ExecIDs []string `json:"ExecIDs"`
+	Config  struct {
+		User       string            `json:"User"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Config"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+		// published ports, e.g. 3142/tcp
+		Ports map[string][]struct {
+			HostIp   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+type networkInfo struct {
+	ID     string            `json:"Id"`
+	Name   string            `json:"Name"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// NetworkIP returns the address of the container in a network
+func (c containerInfo) NetworkIP(network string) string {
+	return c.NetworkSettings.Networks[network].IPAddress
+}
+
+// LocalPort returns the host port a container port (e.g. 3142/tcp)
+// is published on, empty if it isn't
+func (c containerInfo) LocalPort(port string) string {
+	for _, binding := range c.NetworkSettings.Ports[port] {
+		if binding.HostPort != "" {
+			return binding.HostPort
+		}
+	}
+	return ""
+}
+
+// containerSpec describes the helper containers launched by dogi
+type containerSpec struct {
+	Name    string
+	Image   string
+	Volumes []string
+	Restart string
+	Labels  map[string]string
+	Network string
+	// LocalPorts are published on 127.0.0.1, container port to host port
+	LocalPorts map[string]string
+	Env        []string
+}
+
+type pruneReport struct {
+	Containers, Images, Volumes int
+	SpaceReclaimed              uint64
+}
+
+var (
+	backendInstance Backend
+	backendErr      error
+	// the startup phases of dogi run use it concurrently
+	backendOnce sync.Once
+)
+
+func backend() Backend {
+	backendOnce.Do(func() {
+		switch runtimeCli() {
+		case podmanCmd:
+			backendInstance = podmanBackend{}
+		default:
+			backendInstance, backendErr = newDockerBackend()
+		}
+	})
+	check(backendErr)
+	return backendInstance
+}
+
+// runtimeCli returns the --runtime-cli value, or docker if available
+// and podman otherwise
+func runtimeCli() string {
+	switch opts.runtimeCli {
+	case dockerCmd, podmanCmd:
+		return opts.runtimeCli
+	case "":
+	default:
+		logger.Fatalf("Error: --runtime-cli must be %s or %s, not '%s'",
+			dockerCmd, podmanCmd, opts.runtimeCli)
+	}
+
+	dockerPath, err := exec.LookPath(dockerCmd)
+	if err == nil {
+		// podman-docker installs docker as a podman wrapper
+		if realPath, err := filepath.EvalSymlinks(dockerPath); err == nil &&
+			filepath.Base(realPath) == podmanCmd {
+			return podmanCmd
+		}
+		return dockerCmd
+	}
+	if _, err := exec.LookPath(podmanCmd); err == nil {
+		logger.Printf("%s not found, using %s", dockerCmd, podmanCmd)
+		return podmanCmd
+	}
+	return dockerCmd
+}
+
+func imageExists(imageName string) bool {
+	_, err := imageMetadata(imageName)
+	return err == nil
+}
+
+func shortId(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	const maxStr = 12
+	if len(id) > maxStr {
+		return id[:maxStr]
+	}
+	return id
+}
+
+func humanSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.3g%cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	case d < 60*24*time.Hour:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%d months", int(d.Hours()/24/30))
+}
+
+func ago(unix int64) string {
+	return humanDuration(time.Since(time.Unix(unix, 0))) + " ago"
+}
+
+// tableLines renders rows as aligned columns, the first row is the header
+func tableLines(rows [][]string) []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 0, 3, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	check(w.Flush())
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// imageLines returns a docker images like table
+func imageLines(images []imageSummary) []string {
+	rows := [][]string{{"REPOSITORY", "TAG", "IMAGE ID", "CREATED", "SIZE"}}
+	for _, img := range images {
+		tags := img.RepoTags
+		if len(tags) == 0 {
+			tags = []string{"<none>:<none>"}
+		}
+		for _, tag := range tags {
+			k := strings.LastIndex(tag, ":")
+			rows = append(rows, []string{tag[:k], tag[k+1:],
+				shortId(img.ID), ago(img.Created), humanSize(img.Size)})
+		}
+	}
+	return tableLines(rows)
+}
+
+// containerLines returns a docker ps like table
+func containerLines(containers []containerSummary) []string {
+	rows := [][]string{{"CONTAINER ID", "IMAGE", "COMMAND", "CREATED", "STATUS", "NAMES"}}
+	for _, cont := range containers {
+		command := cont.Command
+		const maxCommand = 20
+		if len(command) > maxCommand {
+			command = command[:maxCommand-1] + "…"
+		}
+		names := []string{}
+		for _, name := range cont.Names {
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+		rows = append(rows, []string{shortId(cont.ID), cont.Image,
+			fmt.Sprintf("%q", command), ago(cont.Created), cont.Status,
+			strings.Join(names, ",")})
+	}
+	return tableLines(rows)
+}
@@ -0,0 +1,636 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	maxJsonMessage    = 16 * 1024 * 1024
+)
+
+// dockerBackend talks to the Docker Engine API
+// https://docs.docker.com/reference/api/engine/
+type dockerBackend struct {
+	cli    string
+	client *http.Client
+	// base url for requests, the host is ignored for unix sockets
+	baseUrl string
+}
+
+func newDockerBackend() (*dockerBackend, error) {
+	host, tlsConfig, err := dockerEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return newEngineBackend(dockerCmd, host, tlsConfig)
+}
+
+// dockerContextEndpoint is the part of docker context inspect used by dogi
+type dockerContextEndpoint struct {
+	Endpoints struct {
+		Docker struct {
+			Host          string `json:"Host"`
+			SkipTLSVerify bool   `json:"SkipTLSVerify"`
+		} `json:"docker"`
+	} `json:"Endpoints"`
+	TLSMaterial map[string][]string `json:"TLSMaterial"`
+	Storage     struct {
+		TLSPath string `json:"TLSPath"`
+	} `json:"Storage"`
+}
+
+// dockerConfigDir is $DOCKER_CONFIG or ~/.docker
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// dockerContext returns the current docker context like the cli,
+// empty for the default one
+func dockerContext() string {
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		config := struct {
+			CurrentContext string `json:"currentContext"`
+		}{}
+		if content, err := os.ReadFile(filepath.Join(dockerConfigDir(), "config.json")); err == nil {
+			_ = json.Unmarshal(content, &config)
+		}
+		name = config.CurrentContext
+	}
+	if name == "default" {
+		return ""
+	}
+	return name
+}
+
+// dockerEndpoint returns the daemon the docker cli talks to, so that
+// the api and the cli commands (create, start, exec) use the same one:
+// DOCKER_HOST, else the current context (e.g. rootless docker), else
+// the default socket
+func dockerEndpoint() (string, *tls.Config, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		certPath := os.Getenv("DOCKER_CERT_PATH")
+		if certPath == "" {
+			certPath = dockerConfigDir()
+		}
+		switch {
+		case os.Getenv("DOCKER_TLS_VERIFY") != "":
+			tlsConfig, err := dockerTlsConfig(certPath, false)
+			return host, tlsConfig, err
+		case os.Getenv("DOCKER_TLS") != "":
+			tlsConfig, err := dockerTlsConfig(certPath, true)
+			return host, tlsConfig, err
+		}
+		return host, nil, nil
+	}
+
+	name := dockerContext()
+	if name == "" {
+		return defaultDockerHost, nil, nil
+	}
+	out, err := exec.Command(dockerCmd, "context", "inspect", name).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("%s context inspect %s failed: %w", dockerCmd, name, err)
+	}
+	contexts := []dockerContextEndpoint{}
+	if err := json.Unmarshal(out, &contexts); err != nil || len(contexts) == 0 {
+		return "", nil, fmt.Errorf("invalid %s context '%s': %v", dockerCmd, name, err)
+	}
+	endpoint := contexts[0]
+	logger.Printf("%s context %s: %s\n", dockerCmd, name, endpoint.Endpoints.Docker.Host)
+	if _, ok := endpoint.TLSMaterial["docker"]; !ok {
+		return endpoint.Endpoints.Docker.Host, nil, nil
+	}
+	tlsConfig, err := dockerTlsConfig(filepath.Join(endpoint.Storage.TLSPath, "docker"),
+		endpoint.Endpoints.Docker.SkipTLSVerify)
+	return endpoint.Endpoints.Docker.Host, tlsConfig, err
+}
+
+// dockerTlsConfig loads the ca.pem, cert.pem and key.pem of a docker
+// cert dir, the missing ones aren't used
+func dockerTlsConfig(certPath string, skipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipVerify}
+	if ca, err := os.ReadFile(filepath.Join(certPath, "ca.pem")); err == nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid %s", filepath.Join(certPath, "ca.pem"))
+		}
+	}
+	certFile, keyFile := filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newEngineBackend connects to an Engine API compatible daemon at host,
+// with tls if tlsConfig isn't nil
+func newEngineBackend(cli, host string, tlsConfig *tls.Config) (*dockerBackend, error) {
+	hostUrl, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s host '%s': %w", cli, host, err)
+	}
+
+	b := &dockerBackend{cli: cli}
+	switch hostUrl.Scheme {
+	case "unix":
+		socket := hostUrl.Path
+		b.baseUrl = "http://" + cli
+		b.client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+	case "tcp", "http", "https":
+		if tlsConfig != nil || hostUrl.Scheme == "https" {
+			b.baseUrl = "https://" + hostUrl.Host
+		} else {
+			b.baseUrl = "http://" + hostUrl.Host
+		}
+		b.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	default:
+		return nil, fmt.Errorf("%s host '%s' not supported (only unix:// and tcp://)",
+			cli, host)
+	}
+	return b, nil
+}
+
+// escapePath escapes the image and container names of the api paths,
+// keeping the slashes of image names (e.g. dogi/apt-cacher)
+func escapePath(name string) string {
+	parts := strings.Split(name, "/")
+	for k := range parts {
+		parts[k] = url.PathEscape(parts[k])
+	}
+	return strings.Join(parts, "/")
+}
+
+func (b *dockerBackend) Name() string {
+	return b.cli
+}
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+func (b *dockerBackend) request(method, apiPath string, query url.Values,
+	contentType string, body io.Reader) (*http.Response, error) {
+	return b.requestContext(context.Background(), method, apiPath, query, contentType, body)
+}
+
+// requestContext is request canceled with ctx, for the long ones
+func (b *dockerBackend) requestContext(ctx context.Context, method, apiPath string, query url.Values,
+	contentType string, body io.Reader) (*http.Response, error) {
+	reqUrl := b.baseUrl + apiPath
+	if len(query) > 0 {
+		reqUrl += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s daemon not reachable (is it running?): %w", b.cli, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		apiErr := apiError{}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = resp.Status
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, apiPath, apiErr.Message)
+	}
+	return resp, nil
+}
+
+// call does a request with an optional json body, decoding the
+// json response into out if it isn't nil
+func (b *dockerBackend) call(method, apiPath string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	resp, err := b.request(method, apiPath, query, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (b *dockerBackend) Images() ([]imageSummary, error) {
+	images := []imageSummary{}
+	return images, b.call("GET", "/images/json", nil, nil, &images)
+}
+
+func (b *dockerBackend) Containers(all bool) ([]containerSummary, error) {
+	containers := []containerSummary{}
+	query := url.Values{}
+	if all {
+		query.Set("all", "true")
+	}
+	return containers, b.call("GET", "/containers/json", query, nil, &containers)
+}
+
+func (b *dockerBackend) Volumes() ([]volumeSummary, error) {
+	resp := struct {
+		Volumes []volumeSummary `json:"Volumes"`
+	}{}
+	err := b.call("GET", "/volumes", nil, nil, &resp)
+	return resp.Volumes, err
+}
+
+func (b *dockerBackend) InspectImage(name string) (imageInfo, error) {
+	info := imageInfo{}
+	return info, b.call("GET", "/images/"+escapePath(name)+"/json", nil, nil, &info)
+}
+
+func (b *dockerBackend) InspectContainer(name string) (containerInfo, error) {
+	info := containerInfo{}
+	return info, b.call("GET", "/containers/"+escapePath(name)+"/json", nil, nil, &info)
+}
+
+// readArchiveFile reads a file from the tar archives returned by
+// archive(path), following symlinks
+func readArchiveFile(archive func(string) (io.ReadCloser, error), filePath string) ([]byte, error) {
+	const maxLinks = 10
+	for range maxLinks {
+		body, err := archive(filePath)
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(body)
+		hdr, err := tr.Next()
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			defer body.Close()
+			return io.ReadAll(tr)
+		}
+		body.Close()
+		if path.IsAbs(hdr.Linkname) {
+			filePath = hdr.Linkname
+		} else {
+			filePath = path.Join(path.Dir(filePath), hdr.Linkname)
+		}
+	}
+	return nil, fmt.Errorf("too many symlinks reading %s", filePath)
+}
+
+func (b *dockerBackend) ReadImageFile(image, filePath string) ([]byte, error) {
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	// the container is never started, the entrypoint doesn't matter
+	config := map[string]interface{}{
+		"Image":      image,
+		"Entrypoint": []string{"cat"},
+		"Labels":     map[string]string{labelVersion: Version, labelHelper: "true"},
+	}
+	if err := b.call("POST", "/containers/create", nil, config, &created); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = b.Remove(created.ID)
+	}()
+	return readArchiveFile(func(filePath string) (io.ReadCloser, error) {
+		return b.ArchiveFrom(created.ID, filePath)
+	}, filePath)
+}
+
+func (b *dockerBackend) Create(args []string) (string, error) {
+	out, err := exec.Command(b.cli, merge([]string{"create"}, args)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s create failed: %w\n%s", b.cli, err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// addToTar writes srcpath as dstname into tw, keeping owners
+func addToTar(tw *tar.Writer, srcpath, dstname string) error {
+	return filepath.Walk(srcpath, func(filePath string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// follow symlinks, like docker cp -L
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcpath, filePath)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(dstname, filepath.ToSlash(rel))
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			// keep owner, like docker cp -a
+			hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+			hdr.Uname, hdr.Gname = "", ""
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+}
+
+// copyArchive returns a tar archive to extract at / with srcpath as dstpath
+func copyArchive(srcpath, dstpath string) (*bytes.Buffer, error) {
+	srcpath, err := filepath.EvalSymlinks(srcpath)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := addToTar(tw, srcpath, strings.TrimPrefix(path.Clean(dstpath), "/")); err != nil {
+		return nil, err
+	}
+	return &buf, tw.Close()
+}
+
+func (b *dockerBackend) CopyTo(contId, srcpath, dstpath string) error {
+	buf, err := copyArchive(srcpath, dstpath)
+	if err != nil {
+		return err
+	}
+	// parent directories are created when extracting
+	return b.ArchiveTo(contId, "/", buf)
+}
+
+func (b *dockerBackend) ArchiveFrom(name, srcpath string) (io.ReadCloser, error) {
+	resp, err := b.request("GET", "/containers/"+escapePath(name)+"/archive",
+		url.Values{"path": {srcpath}}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *dockerBackend) ArchiveTo(name, dstpath string, archive io.Reader) error {
+	resp, err := b.request("PUT", "/containers/"+escapePath(name)+"/archive",
+		url.Values{"path": {dstpath}}, "application/x-tar", archive)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *dockerBackend) SaveImage(name string) (io.ReadCloser, error) {
+	resp, err := b.request("GET", "/images/"+escapePath(name)+"/get", nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *dockerBackend) LoadImage(archive io.Reader) error {
+	resp, err := b.request("POST", "/images/load", url.Values{"quiet": {"1"}},
+		"application/x-tar", archive)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = jsonStream(resp.Body)
+	return err
+}
+
+// jsonStream reads the stream of json messages of build and load,
+// it returns their output and the first error
+func jsonStream(body io.Reader) ([]string, error) {
+	output := []string{}
+	scanner := bufio.NewScanner(body)
+	// a message is a whole line of output, which can be long
+	scanner.Buffer(make([]byte, 64*1024), maxJsonMessage)
+	for scanner.Scan() {
+		msg := struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		output = append(output, msg.Stream)
+		if msg.Error != "" {
+			return output, errors.New(msg.Error)
+		}
+	}
+	return output, scanner.Err()
+}
+
+func (b *dockerBackend) RunDetached(spec containerSpec) (string, error) {
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	exposed := map[string]struct{}{}
+	bindings := map[string][]map[string]string{}
+	for port, hostPort := range spec.LocalPorts {
+		exposed[port] = struct{}{}
+		bindings[port] = []map[string]string{{"HostIp": "127.0.0.1", "HostPort": hostPort}}
+	}
+	config := map[string]interface{}{
+		"Image":        spec.Image,
+		"Labels":       spec.Labels,
+		"Env":          spec.Env,
+		"ExposedPorts": exposed,
+		"HostConfig": map[string]interface{}{
+			"Binds":         spec.Volumes,
+			"RestartPolicy": map[string]string{"Name": spec.Restart},
+			"NetworkMode":   spec.Network,
+			"PortBindings":  bindings,
+		},
+	}
+	if err := b.call("POST", "/containers/create",
+		url.Values{"name": {spec.Name}}, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, b.call("POST", "/containers/"+created.ID+"/start", nil, nil, nil)
+}
+
+func (b *dockerBackend) Stop(name string) error {
+	return b.call("POST", "/containers/"+escapePath(name)+"/stop", nil, nil, nil)
+}
+
+func (b *dockerBackend) Remove(name string) error {
+	return b.call("DELETE", "/containers/"+escapePath(name),
+		url.Values{"force": {"true"}, "v": {"true"}}, nil, nil)
+}
+
+func (b *dockerBackend) RemoveVolume(name string) error {
+	return b.call("DELETE", "/volumes/"+escapePath(name), nil, nil, nil)
+}
+
+func (b *dockerBackend) RemoveImage(name string) error {
+	return b.call("DELETE", "/images/"+escapePath(name), nil, nil, nil)
+}
+
+func (b *dockerBackend) InspectNetwork(name string) (networkInfo, error) {
+	info := networkInfo{}
+	return info, b.call("GET", "/networks/"+escapePath(name), nil, nil, &info)
+}
+
+func (b *dockerBackend) CreateNetwork(name string, labels map[string]string) error {
+	return b.call("POST", "/networks/create", nil,
+		map[string]interface{}{"Name": name, "Labels": labels}, nil)
+}
+
+func (b *dockerBackend) ConnectNetwork(network, container string) error {
+	return b.call("POST", "/networks/"+escapePath(network)+"/connect", nil,
+		map[string]string{"Container": container}, nil)
+}
+
+// Exec goes through the cli, the api streams the output multiplexed
+func (b *dockerBackend) Exec(name string, command ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(b.cli, merge([]string{"exec", name}, command)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s exec %s failed: %w\n%s", b.cli, name, err, stderr.String())
+	}
+	return out, nil
+}
+
+func (b *dockerBackend) StartAttachArgs(contId string) []string {
+	return []string{b.cli, "start", "-ai", contId}
+}
+
+func (b *dockerBackend) ExecArgs(args []string) []string {
+	return merge([]string{b.cli, "exec"}, args)
+}
+
+func (b *dockerBackend) Prune() (pruneReport, error) {
+	report := pruneReport{}
+	pruned := struct {
+		ContainersDeleted []string
+		ImagesDeleted     []struct{ Deleted string }
+		VolumesDeleted    []string
+		SpaceReclaimed    uint64
+	}{}
+
+	logger.Println("prune containers...")
+	if err := b.call("POST", "/containers/prune", nil, nil, &pruned); err != nil {
+		return report, err
+	}
+	report.Containers = len(pruned.ContainersDeleted)
+	report.SpaceReclaimed += pruned.SpaceReclaimed
+
+	logger.Println("prune images...")
+	pruned.SpaceReclaimed = 0
+	if err := b.call("POST", "/images/prune", nil, nil, &pruned); err != nil {
+		return report, err
+	}
+	for _, img := range pruned.ImagesDeleted {
+		if img.Deleted != "" {
+			report.Images++
+		}
+	}
+	report.SpaceReclaimed += pruned.SpaceReclaimed
+
+	logger.Println("prune volumes...")
+	pruned.SpaceReclaimed = 0
+	if err := b.call("POST", "/volumes/prune", nil, nil, &pruned); err != nil {
+		return report, err
+	}
+	report.Volumes = len(pruned.VolumesDeleted)
+	report.SpaceReclaimed += pruned.SpaceReclaimed
+	return report, nil
+}
+
+func (b *dockerBackend) Build(ctx context.Context, tag, dockerfile string, files, labels map[string]string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644,
+		Size: int64(len(dockerfile))}); err != nil {
+		return err
+	}
+	if _, err := tw.Write([]byte(dockerfile)); err != nil {
+		return err
+	}
+	for name, srcpath := range files {
+		srcpath, err := filepath.EvalSymlinks(srcpath)
+		if err != nil {
+			return err
+		}
+		if err := addToTar(tw, srcpath, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	labelsJson, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	resp, err := b.requestContext(ctx, "POST", "/build",
+		url.Values{"t": {tag}, "labels": {string(labelsJson)}, "rm": {"true"}},
+		"application/x-tar", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	output, err := jsonStream(resp.Body)
+	if err != nil {
+		// stdout of dogi build is the image name
+		fmt.Fprintln(os.Stderr, strings.Join(output, ""))
+	}
+	return err
+}
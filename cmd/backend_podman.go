@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const podmanCmd = "podman"
+
+// podmanBackend drives the podman cli using its json output, since
+// rootless podman usually runs without an API service
+type podmanBackend struct{}
+
+func (b podmanBackend) Name() string {
+	return podmanCmd
+}
+
+// rootless podman can't do everything root docker does
+func (b podmanBackend) rootless() bool {
+	return os.Geteuid() != 0
+}
+
+func (b podmanBackend) run(stdin io.Reader, args ...string) ([]byte, error) {
+	return b.runContext(context.Background(), stdin, args...)
+}
+
+// runContext is run killed when ctx is canceled
+func (b podmanBackend) runContext(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, podmanCmd, args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s %s: %w: %s", podmanCmd, args[0], err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// cmdOutput is the stdout of a running command, Close waits for it
+type cmdOutput struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (o cmdOutput) Close() error {
+	o.ReadCloser.Close()
+	if err := o.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", podmanCmd, o.cmd.Args[1], err,
+			strings.TrimSpace(o.stderr.String()))
+	}
+	return nil
+}
+
+// stream runs a command whose output is too big for run
+func (b podmanBackend) stream(args ...string) (io.ReadCloser, error) {
+	cmd := exec.Command(podmanCmd, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmdOutput{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+func (b podmanBackend) runJson(out interface{}, args ...string) error {
+	data, err := b.run(nil, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (b podmanBackend) Images() ([]imageSummary, error) {
+	imgs := []struct {
+		imageSummary
+		Names []string `json:"Names"`
+	}{}
+	if err := b.runJson(&imgs, "images", "--format=json"); err != nil {
+		return nil, err
+	}
+	images := make([]imageSummary, len(imgs))
+	for k, img := range imgs {
+		images[k] = img.imageSummary
+		images[k].RepoTags = img.Names
+	}
+	return images, nil
+}
+
+func (b podmanBackend) Containers(all bool) ([]containerSummary, error) {
+	conts := []struct {
+		containerSummary
+		Command []string `json:"Command"`
+	}{}
+	args := []string{"ps", "--format=json"}
+	if all {
+		args = append(args, "--all")
+	}
+	if err := b.runJson(&conts, args...); err != nil {
+		return nil, err
+	}
+	containers := make([]containerSummary, len(conts))
+	for k, cont := range conts {
+		containers[k] = cont.containerSummary
+		containers[k].Command = strings.Join(cont.Command, " ")
+	}
+	return containers, nil
+}
+
+func (b podmanBackend) Volumes() ([]volumeSummary, error) {
+	volumes := []volumeSummary{}
+	return volumes, b.runJson(&volumes, "volume", "ls", "--format=json")
+}
+
+func (b podmanBackend) InspectImage(name string) (imageInfo, error) {
+	infos := []imageInfo{}
+	if err := b.runJson(&infos, "image", "inspect", name); err != nil {
+		return imageInfo{}, err
+	}
+	return infos[0], nil
+}
+
+func (b podmanBackend) InspectContainer(name string) (containerInfo, error) {
+	infos := []containerInfo{}
+	if err := b.runJson(&infos, "container", "inspect", name); err != nil {
+		return containerInfo{}, err
+	}
+	return infos[0], nil
+}
+
+func (b podmanBackend) ReadImageFile(image, filePath string) ([]byte, error) {
+	out, err := b.run(nil, merge([]string{"create", "--entrypoint=cat"},
+		labelArgs(map[string]string{labelVersion: Version, labelHelper: "true"}),
+		[]string{image})...)
+	if err != nil {
+		return nil, err
+	}
+	contId := strings.TrimSpace(string(out))
+	defer func() {
+		_ = b.Remove(contId)
+	}()
+	return readArchiveFile(func(filePath string) (io.ReadCloser, error) {
+		out, err := b.run(nil, "cp", contId+":"+filePath, "-")
+		return io.NopCloser(bytes.NewReader(out)), err
+	}, filePath)
+}
+
+func (b podmanBackend) Create(args []string) (string, error) {
+	out, err := b.run(nil, merge([]string{"create"}, args)...)
+	return strings.TrimSpace(string(out)), err
+}
+
+func (b podmanBackend) CopyTo(contId, srcpath, dstpath string) error {
+	buf, err := copyArchive(srcpath, dstpath)
+	if err != nil {
+		return err
+	}
+	return b.ArchiveTo(contId, "/", buf)
+}
+
+func (b podmanBackend) ArchiveFrom(name, srcpath string) (io.ReadCloser, error) {
+	return b.stream("cp", name+":"+srcpath, "-")
+}
+
+func (b podmanBackend) ArchiveTo(name, dstpath string, archive io.Reader) error {
+	// keep the owners in the archive, like docker cp -a
+	_, err := b.run(archive, "cp", "--archive=false", "-", name+":"+dstpath)
+	return err
+}
+
+func (b podmanBackend) SaveImage(name string) (io.ReadCloser, error) {
+	return b.stream("save", name)
+}
+
+func (b podmanBackend) LoadImage(archive io.Reader) error {
+	_, err := b.run(archive, "load", "--quiet")
+	return err
+}
+
+func (b podmanBackend) RunDetached(spec containerSpec) (string, error) {
+	args := []string{"run", "--detach", "--name=" + spec.Name}
+	if spec.Restart != "" {
+		args = append(args, "--restart="+spec.Restart)
+	}
+	for _, vol := range spec.Volumes {
+		args = append(args, "--volume="+vol)
+	}
+	if spec.Network != "" {
+		args = append(args, "--network="+spec.Network)
+	}
+	for port, hostPort := range spec.LocalPorts {
+		args = append(args, "--publish=127.0.0.1:"+hostPort+":"+port)
+	}
+	for _, env := range spec.Env {
+		args = append(args, "--env="+env)
+	}
+	args = append(args, labelArgs(spec.Labels)...)
+	out, err := b.run(nil, append(args, spec.Image)...)
+	return strings.TrimSpace(string(out)), err
+}
+
+func (b podmanBackend) Stop(name string) error {
+	_, err := b.run(nil, "stop", name)
+	return err
+}
+
+func (b podmanBackend) Remove(name string) error {
+	_, err := b.run(nil, "rm", "--force", "--volumes", name)
+	return err
+}
+
+func (b podmanBackend) RemoveVolume(name string) error {
+	_, err := b.run(nil, "volume", "rm", name)
+	return err
+}
+
+func (b podmanBackend) RemoveImage(name string) error {
+	_, err := b.run(nil, "rmi", name)
+	return err
+}
+
+func (b podmanBackend) InspectNetwork(name string) (networkInfo, error) {
+	infos := []networkInfo{}
+	if err := b.runJson(&infos, "network", "inspect", name); err != nil {
+		return networkInfo{}, err
+	}
+	return infos[0], nil
+}
+
+func (b podmanBackend) CreateNetwork(name string, labels map[string]string) error {
+	_, err := b.run(nil, merge([]string{"network", "create"}, labelArgs(labels), []string{name})...)
+	return err
+}
+
+func (b podmanBackend) ConnectNetwork(network, container string) error {
+	_, err := b.run(nil, "network", "connect", network, container)
+	return err
+}
+
+func (b podmanBackend) Exec(name string, command ...string) ([]byte, error) {
+	return b.run(nil, merge([]string{"exec", name}, command)...)
+}
+
+func (b podmanBackend) StartAttachArgs(contId string) []string {
+	return []string{podmanCmd, "start", "-ai", contId}
+}
+
+func (b podmanBackend) ExecArgs(args []string) []string {
+	return merge([]string{podmanCmd, "exec"}, args)
+}
+
+func (b podmanBackend) Prune() (pruneReport, error) {
+	report := pruneReport{}
+	// each pruned object is printed in its own line
+	count := func(args ...string) (int, error) {
+		out, err := b.run(nil, args...)
+		out = bytes.TrimSpace(out)
+		if len(out) == 0 {
+			return 0, err
+		}
+		return len(bytes.Split(out, []byte("\n"))), err
+	}
+
+	var err error
+	logger.Println("prune containers...")
+	if report.Containers, err = count("container", "prune", "--force"); err != nil {
+		return report, err
+	}
+	logger.Println("prune images...")
+	if report.Images, err = count("image", "prune", "--force"); err != nil {
+		return report, err
+	}
+	logger.Println("prune volumes...")
+	report.Volumes, err = count("volume", "prune", "--force")
+	return report, err
+}
+
+func (b podmanBackend) Build(ctx context.Context, tag, dockerfile string, files, labels map[string]string) error {
+	dir, err := os.MkdirTemp(opts.tempDir, appname+"_build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0666); err != nil {
+		return err
+	}
+	for name, srcpath := range files {
+		content, err := os.ReadFile(srcpath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(srcpath)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	args := merge([]string{"build", "--tag=" + tag}, labelArgs(labels))
+	out, err := b.runContext(ctx, nil, append(args, dir)...)
+	if err != nil {
+		// stdout of dogi build is the image name
+		fmt.Fprintln(os.Stderr, string(out))
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+// fakeBackend serves images (name to their files) and containers from
+// memory, the other methods panic
+type fakeBackend struct {
+	Backend
+	images     map[string]map[string]string
+	summaries  []imageSummary
+	containers []containerSummary
+	infos      map[string]containerInfo
+	removed    []string
+}
+
+func (f *fakeBackend) Name() string { return "fake" }
+
+func (f *fakeBackend) InspectImage(name string) (imageInfo, error) {
+	if _, ok := f.images[name]; !ok {
+		return imageInfo{}, fmt.Errorf("no such image: %s", name)
+	}
+	return imageInfo{ID: "sha256:" + name}, nil
+}
+
+func (f *fakeBackend) ReadImageFile(image, path string) ([]byte, error) {
+	for name, files := range f.images {
+		if image == name || image == "sha256:"+name {
+			if content, ok := files[path]; ok {
+				return []byte(content), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: no such file in %s", path, image)
+}
+
+func (f *fakeBackend) Images() ([]imageSummary, error) {
+	return f.summaries, nil
+}
+
+func (f *fakeBackend) RemoveImage(name string) error {
+	f.removed = append(f.removed, name)
+	return nil
+}
+
+func (f *fakeBackend) InspectContainer(name string) (containerInfo, error) {
+	info, ok := f.infos[name]
+	if !ok {
+		return info, fmt.Errorf("no such container: %s", name)
+	}
+	return info, nil
+}
+
+func (f *fakeBackend) Containers(all bool) ([]containerSummary, error) {
+	return f.containers, nil
+}
+
+// setTestBackend makes backend() return b until the end of the test,
+// without image cache
+func setTestBackend(t *testing.T, b Backend) {
+	backendOnce.Do(func() {})
+	previous, previousMetas := backendInstance, imageMetas
+	backendInstance, imageMetas = b, map[string]imageMeta{}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Cleanup(func() {
+		backendInstance, imageMetas = previous, previousMetas
+	})
+}
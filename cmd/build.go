@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const buildExamples = `
+  - Build an image with your user, sudo and packages already set up,
+    so launching containers from it is faster
+
+    {{.appname}} build ubuntu
+
+  - Launch a container using that image, built if needed
+    (when the base image or your uid/gid changes)
+
+    {{.appname}} run --build ubuntu
+`
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// userImageName returns the name of the image derived from baseImage
+// with the user set up, e.g. dogi/ubuntu-22-04-user:<base image id>
+func userImageName(baseImage, baseId string) string {
+	userObj := userSingleton()
+	repo := strings.Trim(nonAlphanumeric.ReplaceAllString(
+		strings.ToLower(baseImage+"-"+userObj.Username), "-"), "-")
+	return fmt.Sprintf("%s/%s:%s", appname, repo, shortId(baseId))
+}
+
+func userImageLabels(baseImage, baseId string) map[string]string {
+	userObj := userSingleton()
+	return map[string]string{
+		labelVersion:   Version,
+		labelUser:      userObj.Username,
+		labelUid:       userObj.Uid,
+		labelGid:       userObj.Gid,
+		labelBaseImage: baseImage,
+		labelBaseId:    baseId,
+	}
+}
+
+// userImageDockerfile sets up the user with dogi __init, the runtime
+// __init of dogi run then finds everything in place
+func userImageDockerfile(baseImage string, baseMeta imageMeta) string {
+	setup, err := json.Marshal(merge([]string{dogiContainerPath, initCmdName, "--setup-only"},
+		initFlags(userSingleton())))
+	check(err)
+	dockerfile := fmt.Sprintf("FROM %s\nUSER root\nCOPY %s %s\nRUN %s\n",
+		baseImage, appname, dogiContainerPath, setup)
+	if baseMeta.User != "" {
+		dockerfile += fmt.Sprintf("USER %s\n", baseMeta.User)
+	}
+	return dockerfile
+}
+
+// userImage returns the image derived from baseImage with the user set
+// up, building it if it doesn't exist or it's outdated
+func userImage(baseImage string) string {
+	baseImage = fullImageName(baseImage)
+	baseMeta, err := imageMetadata(baseImage)
+	check(err)
+	name := userImageName(baseImage, baseMeta.ID)
+	labels := userImageLabels(baseImage, baseMeta.ID)
+
+	// a concurrent dogi might be building it, then it's up to date
+	defer mustLock(name, false)()
+	if info, err := backend().InspectImage(name); err == nil &&
+		info.Config.Labels[labelBaseId] == baseMeta.ID &&
+		info.Config.Labels[labelUid] == labels[labelUid] &&
+		info.Config.Labels[labelGid] == labels[labelGid] {
+		logger.Printf("user image up to date: %s\n", name)
+		return name
+	}
+
+	dogiPath, err := os.Executable()
+	check(err)
+	dockerfile := userImageDockerfile(baseImage, baseMeta)
+	if opts.dryRun {
+		addLaunchStep("build user image: "+name, strings.Join([]string{
+			"ctx=$(mktemp -d)",
+			shellQuote("cp", "-L", dogiPath) + ` "${ctx}/` + appname + `"`,
+			heredoc(`cat > "${ctx}/Dockerfile"`, dockerfile),
+			shellQuote(merge([]string{backend().Name(), "build", "--tag=" + name},
+				labelArgs(labels))...) + ` "${ctx}"`,
+			`rm -rf "${ctx}"`,
+		}, "\n"))
+		return name
+	}
+
+	logger.Printf("build user image: %s (it might take a while)\n", name)
+	check(backend().Build(context.Background(), name, dockerfile, map[string]string{appname: dogiPath}, labels))
+	removeOldUserImages(name, labels)
+	return name
+}
+
+// fullImageName adds the implicit latest tag, ubuntu and ubuntu:latest
+// get the same user image
+func fullImageName(image string) string {
+	// the registry might have a port
+	last := image
+	if _, after, ok := cutLast(image, "/"); ok {
+		last = after
+	}
+	if strings.ContainsAny(last, ":@") {
+		return image
+	}
+	return image + ":latest"
+}
+
+// removeOldUserImages removes the user images of the same base image
+// and user built from its previous versions (or for another uid/gid),
+// by their labels. The ones still used by a container are kept.
+func removeOldUserImages(name string, labels map[string]string) {
+	current, err := backend().InspectImage(name)
+	if err != nil {
+		logger.Printf("WARNING: old user images not removed: %s\n", err)
+		return
+	}
+	images, err := backend().Images()
+	if err != nil {
+		logger.Printf("WARNING: old user images not removed: %s\n", err)
+		return
+	}
+	for _, img := range images {
+		if img.ID == current.ID || img.Labels[labelBaseImage] != labels[labelBaseImage] ||
+			img.Labels[labelUser] != labels[labelUser] {
+			continue
+		}
+		// rebuilt with the same name, the old one has no tag anymore
+		refs := []string{}
+		for _, tag := range img.RepoTags {
+			if tag != "<none>:<none>" {
+				refs = append(refs, tag)
+			}
+		}
+		if len(refs) == 0 {
+			refs = []string{img.ID}
+		}
+		for _, ref := range refs {
+			if err := backend().RemoveImage(ref); err != nil {
+				logger.Printf("old user image %s not removed: %s\n", ref, err)
+			} else {
+				logger.Printf("old user image %s removed\n", ref)
+			}
+		}
+	}
+}
+
+// checkUserImage exits if the user can't be set up in an image
+func checkUserImage() {
+	if opts.noUser || userSingleton().Uid == "0" {
+		logger.Fatalf("Error: the user image is for your user, it can't be used as root (--no-user or sudo)")
+	}
+	if podmanKeepId() {
+		logger.Fatalf("Error: not needed with rootless podman, it maps your user already")
+	}
+}
+
+var buildCmd = &cobra.Command{
+	Use:   "build [docker-image]",
+	Short: "Build an image with your user set up (faster dogi run)",
+	Long: helpTemplate(`
+Build an image derived from docker-image with your user, groups, sudo and prompt
+already set up, so that dogi run doesn't need to do it on every launch.
+It is only rebuilt when docker-image or your uid/gid change.
+---------------------------------------------
+
+Examples:
+
+{{.buildExamples}}
+---------------------------------------------
+`, map[string]string{"buildExamples": buildExamples}),
+	Args: cobra.MaximumNArgs(1),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return imagesStartingWith(toComplete), cobra.ShellCompDirectiveNoFileComp
+	},
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// keep stdout for the image name
+		logger.SetOutput(os.Stderr)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		checkUserImage()
+		imageName := ""
+		if len(args) == 0 {
+			imageName = selectImage()
+		} else {
+			imageName = args[0]
+		}
+		if !imageExists(imageName) {
+			logger.Fatalf("Error: image '%s' doesn't exist, try: %s pull %s",
+				imageName, backend().Name(), imageName)
+		}
+		fmt.Println(userImage(imageName))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(buildCmd)
+}
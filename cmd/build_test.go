@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFullImageName(t *testing.T) {
+	tests := []struct {
+		image, want string
+	}{
+		{"ubuntu", "ubuntu:latest"},
+		{"ubuntu:22.04", "ubuntu:22.04"},
+		{"osrf/ros:humble", "osrf/ros:humble"},
+		{"localhost:5000/ros", "localhost:5000/ros:latest"},
+		{"localhost:5000/ros:jazzy", "localhost:5000/ros:jazzy"},
+		{"ubuntu@sha256:0123", "ubuntu@sha256:0123"},
+	}
+	for _, test := range tests {
+		if got := fullImageName(test.image); got != test.want {
+			t.Errorf("fullImageName(%s) = %s, want %s", test.image, got, test.want)
+		}
+	}
+}
+
+func TestRemoveOldUserImages(t *testing.T) {
+	const name = "dogi/foo-bar-latest-me:new"
+	labels := map[string]string{labelBaseImage: "foo/bar:latest", labelUser: "me"}
+	fake := &fakeBackend{
+		images: map[string]map[string]string{name: {}},
+		summaries: []imageSummary{
+			{ID: "sha256:" + name, RepoTags: []string{name}, Labels: labels},
+			// previous base image
+			{ID: "sha256:old", RepoTags: []string{"dogi/foo-bar-latest-me:old"}, Labels: labels},
+			// rebuilt for another uid with the same name
+			{ID: "sha256:dangling", RepoTags: []string{"<none>:<none>"}, Labels: labels},
+			// same repository, another base image
+			{ID: "sha256:other", RepoTags: []string{"dogi/foo-bar-latest-me:other"},
+				Labels: map[string]string{labelBaseImage: "foo-bar:latest", labelUser: "me"}},
+			// another user
+			{ID: "sha256:user", RepoTags: []string{"dogi/foo-bar-latest-you:old"},
+				Labels: map[string]string{labelBaseImage: "foo/bar:latest", labelUser: "you"}},
+			{ID: "sha256:ubuntu", RepoTags: []string{"ubuntu:latest"}},
+		},
+	}
+	setTestBackend(t, fake)
+	removeOldUserImages(name, labels)
+	if want := []string{"dogi/foo-bar-latest-me:old", "sha256:dangling"}; !reflect.DeepEqual(fake.removed, want) {
+		t.Errorf("removed %q, want %q", fake.removed, want)
+	}
+}
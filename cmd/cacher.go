@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ntorresalberto/dogi/assets"
+	"github.com/spf13/cobra"
+)
+
+const cacherExamples = `
+  - Show if the package cache is running, its size and hit ratio
+
+    {{.appname}} cacher status
+
+  - Follow the packages downloaded through it
+
+    {{.appname}} cacher logs -f
+
+  - Delete the cached packages
+
+    {{.appname}} cacher clear
+
+  - Stop using it for every launch (same as always adding --no-cacher),
+    enable reverts it
+
+    {{.appname}} cacher disable
+
+  - Copy the package cache of a machine with internet to offline ones,
+    {{.appname}} run then installs the packages from it (import switches the
+    cache to offline mode, {{.appname}} cacher online reverts it)
+
+    {{.appname}} cacher export cache.tar.zst
+    {{.appname}} cacher import cache.tar.zst
+`
+
+const (
+	aptCacherCacheDir = "/var/cache/apt-cacher-ng"
+	aptCacherLog      = "/var/log/apt-cacher-ng/apt-cacher.log"
+)
+
+var (
+	cacherLogsFollow bool
+	cacherStatsJson  bool
+)
+
+// cacherStats summarizes the apt-cacher-ng log, every file served is
+// an O line and every file downloaded from the mirrors an I line
+type cacherStats struct {
+	Requests    int64   `json:"requests"`
+	Downloads   int64   `json:"downloads"`
+	BytesServed int64   `json:"bytesServed"`
+	BytesFetch  int64   `json:"bytesFetched"`
+	HitRatio    float64 `json:"hitRatio"`
+	CacheSize   int64   `json:"cacheSize"`
+}
+
+func parseCacherLog(content string) cacherStats {
+	stats := cacherStats{}
+	for _, line := range strings.Split(content, "\n") {
+		// time|type|size|client|path
+		fields := strings.Split(line, "|")
+		if len(fields) < 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "O":
+			stats.Requests++
+			stats.BytesServed += size
+		case "I":
+			stats.Downloads++
+			stats.BytesFetch += size
+		}
+	}
+	if stats.BytesServed > 0 && stats.BytesFetch < stats.BytesServed {
+		stats.HitRatio = float64(stats.BytesServed-stats.BytesFetch) / float64(stats.BytesServed)
+	}
+	return stats
+}
+
+// aptCacherStats reads the stats from the running apt-cacher container
+func aptCacherStats() (cacherStats, error) {
+	out, err := backend().Exec(aptCacherCont, "cat", aptCacherLog)
+	if err != nil {
+		return cacherStats{}, err
+	}
+	stats := parseCacherLog(string(out))
+
+	out, err = backend().Exec(aptCacherCont, "du", "-sb", aptCacherCacheDir)
+	if err != nil {
+		return stats, err
+	}
+	if fields := strings.Fields(string(out)); len(fields) > 0 {
+		stats.CacheSize, _ = strconv.ParseInt(fields[0], 10, 64)
+	}
+	return stats, nil
+}
+
+func cacherDisabled() bool {
+	settings, _, err := readUserConfig()
+	check(err)
+	disabled, _ := settings["no-cacher"].(bool)
+	return disabled
+}
+
+// setCacherOffline saves the offline mode of the package cache and
+// relaunches it if it exists, to apply it
+func setCacherOffline(offline bool) {
+	var value interface{}
+	if offline {
+		value = true
+	}
+	path, err := setUserSetting("cacher-offline", value)
+	check(err)
+	opts.cacherOffline = offline
+	fmt.Printf("cacher-offline: %t saved in %s\n", offline, path)
+	if _, err := backend().InspectContainer(aptCacherCont); err == nil {
+		defer mustLock(aptCacherName, true)()
+		check(startAptCacher(context.Background()))
+	}
+}
+
+// checkCacherSupported exits when the apt-cacher can't be used
+func checkCacherSupported() {
+	if rootlessPodman() {
+		logger.Fatalf("Error: apt-cacher not supported with rootless podman")
+	}
+}
+
+// checkCacherEnabled exits when the package cache was disabled, the
+// next dogi run wouldn't use it anyway
+func checkCacherEnabled() {
+	if cacherDisabled() {
+		logger.Fatalf("Error: the package cache is disabled, run '%s cacher enable' first", appname)
+	}
+}
+
+var cacherCmd = &cobra.Command{
+	Use:   "cacher",
+	Short: "Manage the package cache shared by dogi containers (apt-cacher)",
+	Long: helpTemplate(`
+The apt-cacher container caches the packages installed inside dogi containers,
+so they are only downloaded once. It is started by {{.appname}} run when needed.
+---------------------------------------------
+
+Examples:
+
+{{.cacherExamples}}
+---------------------------------------------
+`, map[string]string{"cacherExamples": cacherExamples}),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// it replaces the one of the root command
+		rootCmd.PersistentPreRun(cmd, args)
+		// these only edit the config (and relaunch an existing container)
+		switch cmd {
+		case cacherDisableCmd, cacherEnableCmd, cacherOfflineCmd, cacherOnlineCmd:
+		default:
+			checkCacherSupported()
+		}
+		// the same offline mode as dogi run
+		settings, _, err := readUserConfig()
+		check(err)
+		opts.cacherOffline, _ = settings["cacher-offline"].(bool)
+	},
+}
+
+var cacherStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state, size and hit ratio of the package cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		rows := [][]string{}
+		if cacherDisabled() {
+			rows = append(rows, []string{"enabled:", "no (" + appname + " cacher enable)"})
+		} else {
+			rows = append(rows, []string{"enabled:", "yes"})
+		}
+
+		info, err := backend().InspectContainer(aptCacherCont)
+		if err != nil {
+			rows = append(rows, []string{"container:", "not found, started by " + appname + " run"})
+			fmt.Println(strings.Join(tableLines(rows), "\n"))
+			return
+		}
+		rows = append(rows, []string{"container:",
+			aptCacherCont + " (" + stateLine(info.State.Status, int64(uptime(info).Seconds())) + ")"})
+
+		imgInfo, err := backend().InspectImage(aptCacherImage)
+		if err == nil && imgInfo.ID == info.Image &&
+			imgInfo.Config.Labels[labelDockerfileHash] == dockerfileHash(assets.AptCacheDockerfile) {
+			rows = append(rows, []string{"image:", aptCacherImage + " (up to date)"})
+		} else {
+			rows = append(rows, []string{"image:", aptCacherImage + " (outdated, updated on the next launch)"})
+		}
+
+		if info.State.Running {
+			rows = append(rows, []string{"proxy:", fmt.Sprintf("http://%s (host network), http://%s (%s network)",
+				cacherAddr(info, ""), cacherAddr(info, dogiNetwork), dogiNetwork)})
+			if stats, err := aptCacherStats(); err != nil {
+				logger.Printf("WARNING: failed to read the cache stats: %s\n", err)
+			} else {
+				rows = append(rows,
+					[]string{"cache size:", humanSize(stats.CacheSize)},
+					[]string{"hit ratio:", fmt.Sprintf("%.0f%% (%s served)",
+						stats.HitRatio*100, humanSize(stats.BytesServed))})
+			}
+		}
+		fmt.Println(strings.Join(tableLines(rows), "\n"))
+	},
+}
+
+var cacherStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the package cache (building its image if needed)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkCacherEnabled()
+		defer mustLock(aptCacherName, true)()
+		check(startAptCacher(context.Background()))
+		fmt.Printf("%s running\n", aptCacherCont)
+	},
+}
+
+var cacherStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the package cache (started again by the next dogi run)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		info, err := backend().InspectContainer(aptCacherCont)
+		if err != nil || !info.State.Running {
+			fmt.Printf("%s not running\n", aptCacherCont)
+			return
+		}
+		check(backend().Stop(aptCacherCont))
+		fmt.Printf("%s stopped\n", aptCacherCont)
+	},
+}
+
+var cacherLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show the packages requested to the package cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		logsArgs := []string{backend().Name(), "logs"}
+		if cacherLogsFollow {
+			logsArgs = append(logsArgs, "--follow")
+		}
+		check(syscall.Exec(dockerBinPath(), append(logsArgs, aptCacherCont), os.Environ()))
+	},
+}
+
+var cacherStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the requests, downloads and size of the package cache",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		stats, err := aptCacherStats()
+		if err != nil {
+			logger.Fatalf("Error: is %s running? (%s cacher start): %s",
+				aptCacherCont, appname, err)
+		}
+		if cacherStatsJson {
+			out, err := json.MarshalIndent(stats, "", "  ")
+			check(err)
+			fmt.Println(string(out))
+			return
+		}
+		fmt.Println(strings.Join(tableLines([][]string{
+			{"requests:", strconv.FormatInt(stats.Requests, 10)},
+			{"downloads:", strconv.FormatInt(stats.Downloads, 10)},
+			{"served:", humanSize(stats.BytesServed)},
+			{"fetched:", humanSize(stats.BytesFetch)},
+			{"hit ratio:", fmt.Sprintf("%.0f%%", stats.HitRatio*100)},
+			{"cache size:", humanSize(stats.CacheSize)},
+		}), "\n"))
+	},
+}
+
+var cacherClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete the cached packages",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer mustLock(aptCacherName, true)()
+		info, err := backend().InspectContainer(aptCacherCont)
+		running := err == nil && info.State.Running
+		if err == nil {
+			check(backend().Remove(aptCacherCont))
+		}
+		if err := backend().RemoveVolume(aptCacherVolume); err != nil {
+			logger.Printf("volume %s not removed: %s\n", aptCacherVolume, err)
+		} else {
+			fmt.Printf("%s removed\n", aptCacherVolume)
+		}
+		// a disabled cache stays stopped
+		if running && !cacherDisabled() {
+			check(startAptCacher(context.Background()))
+		}
+	},
+}
+
+var cacherDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Don't use the package cache by default (like --no-cacher)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := setUserSetting("no-cacher", true)
+		check(err)
+		fmt.Printf("no-cacher: true saved in %s\n", path)
+		// otherwise --restart=always brings it back with the docker daemon
+		defer mustLock(aptCacherName, true)()
+		if _, err := backend().InspectContainer(aptCacherCont); err == nil {
+			check(backend().Remove(aptCacherCont))
+			fmt.Printf("%s removed, the cached packages are kept\n", aptCacherCont)
+		}
+	},
+}
+
+var cacherOfflineCmd = &cobra.Command{
+	Use:   "offline",
+	Short: "Only serve the cached packages, never downloading (like --cacher-offline)",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setCacherOffline(true)
+	},
+}
+
+var cacherOnlineCmd = &cobra.Command{
+	Use:   "online",
+	Short: "Download the packages missing in the cache again",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		setCacherOffline(false)
+	},
+}
+
+var cacherEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Use the package cache by default again",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := setUserSetting("no-cacher", nil)
+		check(err)
+		fmt.Printf("no-cacher removed from %s\n", path)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacherCmd)
+	cacherCmd.AddCommand(cacherStatusCmd, cacherStartCmd, cacherStopCmd, cacherLogsCmd,
+		cacherStatsCmd, cacherClearCmd, cacherDisableCmd, cacherEnableCmd,
+		cacherOfflineCmd, cacherOnlineCmd)
+	cacherLogsCmd.Flags().BoolVarP(&cacherLogsFollow, "follow", "f", false, "keep showing new requests")
+	cacherStatsCmd.Flags().BoolVar(&cacherStatsJson, "json", false, "print the stats as json")
+}
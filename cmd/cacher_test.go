@@ -0,0 +1,27 @@
+package cmd
+
+import "testing"
+
+func TestParseCacherLog(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want cacherStats
+	}{
+		{"empty", "", cacherStats{}},
+		{"all downloaded",
+			"1718000000|I|1000|172.18.0.3|uburep/pool/a.deb\n1718000000|O|1000|172.18.0.3|uburep/pool/a.deb\n",
+			cacherStats{Requests: 1, Downloads: 1, BytesServed: 1000, BytesFetch: 1000}},
+		{"served twice",
+			"1718000000|I|1000|172.18.0.3|a.deb\n1718000000|O|1000|172.18.0.3|a.deb\n1718000100|O|1000|172.18.0.4|a.deb\n",
+			cacherStats{Requests: 2, Downloads: 1, BytesServed: 2000, BytesFetch: 1000, HitRatio: 0.5}},
+		{"malformed lines",
+			"garbage\n1718000000|O|notanumber|x|a.deb\n1718000000|O|300|x|b.deb\n1718000000|E|5|x|c.deb",
+			cacherStats{Requests: 1, BytesServed: 300, HitRatio: 1}},
+	}
+	for _, test := range tests {
+		if got := parseCacherLog(test.log); got != test.want {
+			t.Errorf("%s: parseCacherLog() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
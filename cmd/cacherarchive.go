@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ntorresalberto/dogi/assets"
+	"github.com/spf13/cobra"
+)
+
+// entries of the archive, in this order
+const (
+	cacherArchiveImage    = "image.tar"
+	cacherArchiveCache    = "cache/"
+	cacherArchiveManifest = "manifest.json"
+)
+
+// cacherManifest describes the content of an exported package cache
+type cacherManifest struct {
+	Version        string          `json:"version"`
+	Created        string          `json:"created"`
+	Image          string          `json:"image"`
+	DockerfileHash string          `json:"dockerfileHash"`
+	Distros        []string        `json:"distros"`
+	Packages       []cacherPackage `json:"packages"`
+	Files          int             `json:"files"`
+	Size           int64           `json:"size"`
+}
+
+type cacherPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	Path    string `json:"path"`
+}
+
+// apt-cacher-ng stores the known mirrors with these names
+var cacherRepoNames = map[string]string{
+	"debrep": "debian",
+	"uburep": "ubuntu",
+}
+
+var fedoraRelease = regexp.MustCompile(`/(releases|updates)/([0-9]+)/`)
+
+// parsePackage parses deb (name_version_arch.deb) and rpm
+// (name-version-release.arch.rpm) file names
+func parsePackage(filePath string) (cacherPackage, bool) {
+	base := path.Base(filePath)
+	switch {
+	case strings.HasSuffix(base, ".deb"):
+		fields := strings.Split(strings.TrimSuffix(base, ".deb"), "_")
+		if len(fields) != 3 {
+			return cacherPackage{}, false
+		}
+		// the epoch colon is escaped in the file name
+		version, err := url.PathUnescape(fields[1])
+		if err != nil {
+			version = fields[1]
+		}
+		return cacherPackage{Name: fields[0], Version: version, Arch: fields[2], Path: filePath}, true
+	case strings.HasSuffix(base, ".rpm"):
+		nvr, arch, ok := cutLast(strings.TrimSuffix(base, ".rpm"), ".")
+		if !ok {
+			return cacherPackage{}, false
+		}
+		nv, release, ok := cutLast(nvr, "-")
+		if !ok {
+			return cacherPackage{}, false
+		}
+		name, version, ok := cutLast(nv, "-")
+		if !ok {
+			return cacherPackage{}, false
+		}
+		return cacherPackage{Name: name, Version: version + "-" + release, Arch: arch, Path: filePath}, true
+	}
+	return cacherPackage{}, false
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	k := strings.LastIndex(s, sep)
+	if k < 0 {
+		return s, "", false
+	}
+	return s[:k], s[k+len(sep):], true
+}
+
+// packageDistro returns the distro and release of a cached file,
+// e.g. ubuntu jammy, empty if unknown
+func packageDistro(filePath string) string {
+	parts := strings.Split(filePath, "/")
+	for k := range parts[:len(parts)-1] {
+		if parts[k] == "dists" && k > 0 {
+			repo := parts[0]
+			if name, ok := cacherRepoNames[repo]; ok {
+				repo = name
+			}
+			return repo + " " + parts[k+1]
+		}
+	}
+	if match := fedoraRelease.FindStringSubmatch(filePath); match != nil &&
+		strings.Contains(filePath, "fedora") {
+		return "fedora " + match[2]
+	}
+	return ""
+}
+
+func (m *cacherManifest) add(filePath string, hdr *tar.Header) {
+	if hdr.Typeflag != tar.TypeReg {
+		return
+	}
+	m.Files++
+	m.Size += hdr.Size
+	if pkg, ok := parsePackage(filePath); ok {
+		m.Packages = append(m.Packages, pkg)
+	}
+	if distro := packageDistro(filePath); distro != "" {
+		for _, known := range m.Distros {
+			if known == distro {
+				return
+			}
+		}
+		m.Distros = append(m.Distros, distro)
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, content io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size,
+		ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, content)
+	return err
+}
+
+// exportCacher writes the apt-cacher image and volume to a tar.zst
+// file, the image is needed to start the apt-cacher offline
+func exportCacher(filePath string) (cacherManifest, error) {
+	manifest := cacherManifest{
+		Version:        Version,
+		Created:        time.Now().Format(time.RFC3339),
+		Image:          aptCacherImage,
+		DockerfileHash: dockerfileHash(assets.AptCacheDockerfile),
+		Distros:        []string{},
+		Packages:       []cacherPackage{},
+	}
+	if _, err := backend().InspectContainer(aptCacherCont); err != nil {
+		return manifest, fmt.Errorf("%s not found, nothing to export: %w", aptCacherCont, err)
+	}
+
+	// the size of a tar entry goes first, save the image to a file
+	imgFile, err := os.CreateTemp(opts.tempDir, "."+appname+"_image_*.tar")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.Remove(imgFile.Name())
+	defer imgFile.Close()
+	logger.Printf("save image %s\n", aptCacherImage)
+	img, err := backend().SaveImage(aptCacherImage)
+	if err != nil {
+		return manifest, err
+	}
+	imgSize, err := io.Copy(imgFile, img)
+	if closeErr := img.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if _, err := imgFile.Seek(0, io.SeekStart); err != nil {
+		return manifest, err
+	}
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return manifest, err
+	}
+	defer out.Close()
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		return manifest, err
+	}
+	tw := tar.NewWriter(zw)
+	if err := writeTarFile(tw, cacherArchiveImage, imgFile, imgSize); err != nil {
+		return manifest, err
+	}
+
+	logger.Printf("export %s:%s\n", aptCacherCont, aptCacherCacheDir)
+	cache, err := backend().ArchiveFrom(aptCacherCont, aptCacherCacheDir)
+	if err != nil {
+		return manifest, err
+	}
+	defer cache.Close()
+	tr := tar.NewReader(cache)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, err
+		}
+		// entries start with the cache directory name
+		_, rel, _ := strings.Cut(hdr.Name, "/")
+		hdr.Name = cacherArchiveCache + rel
+		if err := tw.WriteHeader(hdr); err != nil {
+			return manifest, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return manifest, err
+		}
+		manifest.add(rel, hdr)
+	}
+	if err := cache.Close(); err != nil {
+		return manifest, err
+	}
+
+	sort.Strings(manifest.Distros)
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeTarFile(tw, cacherArchiveManifest, strings.NewReader(string(content)),
+		int64(len(content))); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	if err := zw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, out.Close()
+}
+
+// importCacher loads the apt-cacher image of an exported package
+// cache, starts the apt-cacher and adds the cached files to its volume,
+// the caller holds the apt-cacher lock
+func importCacher(filePath string) (manifest cacherManifest, err error) {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return manifest, err
+	}
+	defer in.Close()
+	zr, err := zstd.NewReader(in)
+	if err != nil {
+		return manifest, err
+	}
+	defer zr.Close()
+
+	// the cache files are streamed to the container as they are read
+	var (
+		cacheWriter *io.PipeWriter
+		cacheTar    *tar.Writer
+		cacheDone   = make(chan error, 1)
+	)
+	startCacheImport := func() error {
+		if err := startAptCacher(context.Background()); err != nil {
+			return err
+		}
+		logger.Printf("import into %s:%s\n", aptCacherCont, aptCacherCacheDir)
+		reader, writer := io.Pipe()
+		go func() {
+			err := backend().ArchiveTo(aptCacherCont, path.Dir(aptCacherCacheDir), reader)
+			// unblock the writer if the container stopped reading
+			reader.CloseWithError(fmt.Errorf("import stopped: %v", err))
+			cacheDone <- err
+		}()
+		cacheWriter, cacheTar = writer, tar.NewWriter(writer)
+		return nil
+	}
+	defer func() {
+		// on errors the container stops waiting for more files
+		if cacheWriter != nil && err != nil {
+			cacheWriter.CloseWithError(err)
+			<-cacheDone
+		}
+	}()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, err
+		}
+		switch {
+		case hdr.Name == cacherArchiveImage:
+			logger.Printf("load image %s\n", aptCacherImage)
+			if err := backend().LoadImage(tr); err != nil {
+				return manifest, err
+			}
+		case strings.HasPrefix(hdr.Name, cacherArchiveCache):
+			if cacheTar == nil {
+				if err := startCacheImport(); err != nil {
+					return manifest, err
+				}
+			}
+			hdr.Name = path.Base(aptCacherCacheDir) + "/" + strings.TrimPrefix(hdr.Name, cacherArchiveCache)
+			if err := cacheTar.WriteHeader(hdr); err != nil {
+				return manifest, err
+			}
+			if _, err := io.Copy(cacheTar, tr); err != nil {
+				return manifest, err
+			}
+		case hdr.Name == cacherArchiveManifest:
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, err
+			}
+		}
+	}
+	if cacheTar == nil {
+		return manifest, fmt.Errorf("%s has no package cache", filePath)
+	}
+	if err := cacheTar.Close(); err != nil {
+		return manifest, err
+	}
+	cacheWriter.Close()
+	importErr := <-cacheDone
+	cacheWriter = nil
+	if importErr != nil {
+		return manifest, importErr
+	}
+
+	// the files might come from another uid, restart to pick them up
+	if _, err := backend().Exec(aptCacherCont, "chown", "-R",
+		"apt-cacher-ng:apt-cacher-ng", aptCacherCacheDir); err != nil {
+		logger.Printf("WARNING: failed to set the owner of the cache: %s\n", err)
+	}
+	if err := backend().Remove(aptCacherCont); err != nil {
+		return manifest, err
+	}
+	return manifest, startAptCacher(context.Background())
+}
+
+// onlineOnly is true for the distros whose cached packages can't be
+// installed offline: dnf gets its mirrors from https metalinks, which
+// go through the apt-cacher without being cached
+func onlineOnly(distro string) bool {
+	return strings.HasPrefix(distro, familyFedora+" ")
+}
+
+func printCacherManifest(manifest cacherManifest) {
+	names := []string{}
+	for _, distro := range manifest.Distros {
+		if onlineOnly(distro) {
+			distro += " (online only)"
+		}
+		names = append(names, distro)
+	}
+	distros := strings.Join(names, ", ")
+	if distros == "" {
+		distros = "unknown"
+	}
+	fmt.Println(strings.Join(tableLines([][]string{
+		{"packages:", fmt.Sprint(len(manifest.Packages))},
+		{"distros:", distros},
+		{"files:", fmt.Sprintf("%d (%s)", manifest.Files, humanSize(manifest.Size))},
+		{"created:", manifest.Created + " by " + appname + " " + manifest.Version},
+	}), "\n"))
+}
+
+var cacherExportCmd = &cobra.Command{
+	Use:   "export <file.tar.zst>",
+	Short: "Save the package cache and its image to a file (for offline machines)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		manifest, err := exportCacher(args[0])
+		if err != nil {
+			os.Remove(args[0])
+			logger.Fatalf("Error: export failed: %s", err)
+		}
+		printCacherManifest(manifest)
+		fmt.Printf("package cache exported to %s\n", args[0])
+	},
+}
+
+var cacherImportCmd = &cobra.Command{
+	Use:   "import <file.tar.zst>",
+	Short: "Load a package cache saved with dogi cacher export",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkCacherEnabled()
+		opts.cacherOffline = !cacherImportOnline
+		unlock := mustLock(aptCacherName, true)
+		manifest, err := importCacher(args[0])
+		unlock()
+		if err != nil {
+			logger.Fatalf("Error: import failed: %s", err)
+		}
+		printCacherManifest(manifest)
+		fmt.Printf("package cache imported into %s\n", aptCacherVolume)
+		if cacherImportOnline {
+			return
+		}
+		path, err := setUserSetting("cacher-offline", true)
+		check(err)
+		fmt.Printf("offline mode (cacher-offline: true) saved in %s, %s cacher online reverts it\n",
+			path, appname)
+		for _, distro := range manifest.Distros {
+			if onlineOnly(distro) {
+				logger.Printf("WARNING: %s packages can't be installed offline, dnf needs its https metalinks\n", distro)
+			}
+		}
+	},
+}
+
+var cacherImportOnline bool
+
+func init() {
+	cacherCmd.AddCommand(cacherExportCmd, cacherImportCmd)
+	cacherImportCmd.Flags().BoolVar(&cacherImportOnline, "online", false, "keep downloading the packages missing in the cache (no offline mode)")
+}
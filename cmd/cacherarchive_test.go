@@ -0,0 +1,59 @@
+package cmd
+
+import "testing"
+
+func TestParsePackage(t *testing.T) {
+	tests := []struct {
+		path string
+		want cacherPackage
+		ok   bool
+	}{
+		{"uburep/pool/main/c/curl/curl_7.81.0-1ubuntu1.15_amd64.deb",
+			cacherPackage{Name: "curl", Version: "7.81.0-1ubuntu1.15", Arch: "amd64"}, true},
+		// the epoch colon is escaped
+		{"debrep/pool/main/v/vim/vim_2%3a9.0.1378-2_arm64.deb",
+			cacherPackage{Name: "vim", Version: "2:9.0.1378-2", Arch: "arm64"}, true},
+		{"fedora/updates/40/Everything/x86_64/Packages/g/git-core-2.45.2-1.fc40.x86_64.rpm",
+			cacherPackage{Name: "git-core", Version: "2.45.2-1.fc40", Arch: "x86_64"}, true},
+		{"fedora/releases/40/Everything/noarch/os/Packages/t/tzdata-2024a-5.fc40.noarch.rpm",
+			cacherPackage{Name: "tzdata", Version: "2024a-5.fc40", Arch: "noarch"}, true},
+		{"uburep/pool/main/c/curl/curl_7.81.0.deb", cacherPackage{}, false},
+		{"fedora/Packages/norelease.x86_64.rpm", cacherPackage{}, false},
+		{"uburep/dists/jammy/InRelease", cacherPackage{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parsePackage(test.path)
+		if ok != test.ok {
+			t.Errorf("parsePackage(%q) ok = %t, want %t", test.path, ok, test.ok)
+			continue
+		}
+		if ok {
+			test.want.Path = test.path
+		}
+		if got != test.want {
+			t.Errorf("parsePackage(%q) = %+v, want %+v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestPackageDistro(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"uburep/dists/jammy/InRelease", "ubuntu jammy"},
+		{"debrep/dists/bookworm/main/binary-amd64/Packages.xz", "debian bookworm"},
+		{"security.ubuntu.com/ubuntu/dists/noble-security/InRelease", "security.ubuntu.com noble-security"},
+		{"fedora/updates/40/Everything/x86_64/repodata/repomd.xml", "fedora 40"},
+		{"fedora/releases/39/Everything/x86_64/os/Packages/b/bash-5.2.21-1.fc39.x86_64.rpm", "fedora 39"},
+		{"epel/updates/9/Everything/x86_64/repodata/repomd.xml", ""},
+		{"uburep/pool/main/c/curl/curl_7.81.0-1ubuntu1.15_amd64.deb", ""},
+		// dists as a file name isn't a release
+		{"uburep/dists", ""},
+	}
+	for _, test := range tests {
+		if got := packageDistro(test.path); got != test.want {
+			t.Errorf("packageDistro(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
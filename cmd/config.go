@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const configFileName = "." + appname + ".yaml"
+
+// userConfigFileName is in the user config dir, e.g. ~/.config/dogi
+const userConfigFileName = "config.yaml"
+
+const configExamples = `
+  - Share a launch setup with your team by adding a .{{.appname}}.yaml
+    file to the root of your repository (flags always win over it)
+
+    image: osrf/ros:humble
+    gpus-all: true
+    no-usb: true
+    name: foo
+    device-access: /dev/video0
+    volumes:
+      - ./data:/data
+    command: [bash, -c, "colcon build && bash"]
+
+  - Your own defaults for every launch go in ~/.config/{{.appname}}/config.yaml,
+    with the same flag keys (the project config and flags win over it)
+
+    no-cacher: true
+    audio: true
+    supervise: true
+`
+
+// options is the single source of settings for run and exec,
+// flags are bound to its fields and a project config can fill them.
+type options struct {
+	nvidiaRuntime bool
+	gpusAll       bool
+	privileged    bool
+	noUser        bool
+	recentCtr     bool
+	noRM          bool
+	noUSB         bool
+	noNethost     bool
+	noCacher      bool
+	noPIDIPCHost  bool
+	headless      bool
+	audio         bool
+	noAgents      bool
+	buildImage    bool
+	dryRun        bool
+	printScript   bool
+	timings       bool
+	supervise     bool
+	noTools       bool
+	cacherOffline bool
+	workDir       string
+	contName      string
+	devAcc        string
+	devRMW        string
+	tempDir       string
+	runtimeCli    string
+
+	// only settable through the config file
+	image      string
+	volumes    []string
+	command    []string
+	configPath string
+}
+
+var opts options
+
+// commands whose flags can be set through a config file
+var configCommands []*cobra.Command
+
+func knownSetting(name string) bool {
+	for _, cmd := range configCommands {
+		if cmd.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// projectConfig is the content of a .dogi.yaml file, any key other
+// than image, volumes and command is the name of a run/exec flag.
+type projectConfig struct {
+	Image   string                 `yaml:"image"`
+	Volumes []string               `yaml:"volumes"`
+	Command []string               `yaml:"command"`
+	Flags   map[string]interface{} `yaml:",inline"`
+}
+
+// findProjectConfig walks up from dir looking for a config file,
+// it returns an empty string if none was found.
+func findProjectConfig(dir string) string {
+	for {
+		path := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// hostPath expands ~ and makes path relative to the config directory
+func hostPath(path, configDir string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		check(err)
+		return filepath.Join(home, path[1:])
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(configDir, path)
+	}
+	return path
+}
+
+func flagValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		// same format as --device-access and --device-rmw
+		vals := make([]string, len(list))
+		for k := range list {
+			vals[k] = fmt.Sprint(list[k])
+		}
+		return strings.Join(vals, ";")
+	}
+	return fmt.Sprint(value)
+}
+
+// applyConfigFlags sets the flags of a config file not set already
+func applyConfigFlags(cmd *cobra.Command, path string, flags map[string]interface{}) {
+	for name, value := range flags {
+		if !knownSetting(name) {
+			logger.Fatalf("Error: %s: unknown setting '%s'", path, name)
+		}
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		val := flagValue(value)
+		// the workdir of exec is a path inside the container
+		if (name == "workdir" && cmd.Name() == "run") || name == "temp-dir" {
+			val = hostPath(val, filepath.Dir(path))
+		}
+		if err := cmd.Flags().Set(name, val); err != nil {
+			logger.Fatalf("Error: %s: invalid value for '%s': %s", path, name, err)
+		}
+	}
+}
+
+// loadConfig applies the project config and then the user config,
+// flags take precedence over both and the project over the user one.
+func loadConfig(cmd *cobra.Command) {
+	loadProjectConfig(cmd)
+	loadUserConfig(cmd)
+}
+
+func userConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appname, userConfigFileName), nil
+}
+
+// readUserConfig returns the settings of the user config file,
+// empty if it doesn't exist
+func readUserConfig() (map[string]interface{}, string, error) {
+	settings := map[string]interface{}{}
+	path, err := userConfigPath()
+	if err != nil {
+		return settings, "", err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, path, nil
+	} else if err != nil {
+		return settings, path, err
+	}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return settings, path, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return settings, path, nil
+}
+
+func loadUserConfig(cmd *cobra.Command) {
+	settings, path, err := readUserConfig()
+	if err != nil {
+		logger.Fatalf("Error: %s", err)
+	}
+	if len(settings) == 0 {
+		return
+	}
+	logger.Printf("user config: %s\n", path)
+	applyConfigFlags(cmd, path, settings)
+}
+
+// setUserSetting persists a flag value in the user config file,
+// a nil value removes it
+func setUserSetting(name string, value interface{}) (string, error) {
+	settings, path, err := readUserConfig()
+	if err != nil {
+		return path, err
+	}
+	if value == nil {
+		delete(settings, name)
+	} else {
+		settings[name] = value
+	}
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return path, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return path, err
+	}
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// loadProjectConfig applies the closest config file to the options,
+// values set through flags take precedence over the file.
+func loadProjectConfig(cmd *cobra.Command) {
+	cwd, err := os.Getwd()
+	check(err)
+	path := findProjectConfig(cwd)
+	if path == "" {
+		return
+	}
+	logger.Printf("project config: %s\n", path)
+
+	data, err := os.ReadFile(path)
+	check(err)
+	conf := projectConfig{}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		logger.Fatalf("Error: failed to parse %s: %s", path, err)
+	}
+
+	applyConfigFlags(cmd, path, conf.Flags)
+
+	opts.configPath = path
+	opts.image = conf.Image
+	opts.command = conf.Command
+	for _, vol := range conf.Volumes {
+		spl := strings.SplitN(vol, ":", 2)
+		if strings.HasPrefix(spl[0], ".") || strings.HasPrefix(spl[0], "~") {
+			// otherwise it's an absolute path or a named volume
+			spl[0] = hostPath(spl[0], filepath.Dir(path))
+		}
+		opts.volumes = append(opts.volumes, strings.Join(spl, ":"))
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// setTestConfig writes the user and project config files and makes the
+// project dir the working directory, the flags and options are restored
+// on cleanup
+func setTestConfig(t *testing.T, user, project string) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	if user != "" {
+		path := filepath.Join(dir, "config", appname, userConfigFileName)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(user), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	projectDir := filepath.Join(dir, "project")
+	if err := os.MkdirAll(filepath.Join(projectDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, configFileName), []byte(project), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(filepath.Join(projectDir, "sub"))
+
+	saved := opts
+	t.Cleanup(func() {
+		opts = saved
+		for _, cmd := range configCommands {
+			cmd.Flags().VisitAll(func(flag *pflag.Flag) { flag.Changed = false })
+		}
+	})
+	return projectDir
+}
+
+func parseTestFlags(t *testing.T, cmd *cobra.Command, args ...string) {
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	projectDir := setTestConfig(t,
+		"no-cacher: true\naudio: true\nname: user\n",
+		"audio: false\nname: project\nworkdir: src\nimage: ubuntu\nvolumes: [./data:/data]\n")
+	parseTestFlags(t, runCmd, "--name", "flag")
+	loadConfig(runCmd)
+
+	if !opts.noCacher {
+		t.Errorf("no-cacher = false, want true from the user config")
+	}
+	if opts.audio {
+		t.Errorf("audio = true, want false from the project config")
+	}
+	if opts.contName != "flag" {
+		t.Errorf("name = %q, want %q from the flag", opts.contName, "flag")
+	}
+	if want := filepath.Join(projectDir, "src"); opts.workDir != want {
+		t.Errorf("workdir = %q, want %q", opts.workDir, want)
+	}
+	if opts.image != "ubuntu" {
+		t.Errorf("image = %q, want %q", opts.image, "ubuntu")
+	}
+	if want := filepath.Join(projectDir, "data") + ":/data"; len(opts.volumes) != 1 || opts.volumes[0] != want {
+		t.Errorf("volumes = %q, want [%q]", opts.volumes, want)
+	}
+}
+
+func TestLoadConfigExecWorkdir(t *testing.T) {
+	for _, workdir := range []string{"src", "/ws/src"} {
+		t.Run(workdir, func(t *testing.T) {
+			setTestConfig(t, "", "workdir: "+workdir+"\n")
+			loadConfig(execCmd)
+			// a path inside the container, not relative to the config
+			if opts.workDir != workdir {
+				t.Errorf("exec workdir = %q, want %q", opts.workDir, workdir)
+			}
+		})
+	}
+}
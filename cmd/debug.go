@@ -1,13 +1,10 @@
 package cmd
 
 import (
-	// "context"
 	"fmt"
-	"syscall"
+	"strings"
 
 	"github.com/spf13/cobra"
-	// "github.com/docker/docker/api/types"
-	// "github.com/docker/docker/client"
 )
 
 var debugCmd = &cobra.Command{
@@ -15,28 +12,23 @@ var debugCmd = &cobra.Command{
 	Short: fmt.Sprintf("To debug %s!", appname),
 	Long:  `This command provides an interface to test and see internal dogi information.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// ctx := context.Background()
-		// cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		// check(err)
+		logger.Printf("backend: %s", backend().Name())
 
-		// // images
-		// imgs, err := cli.ImageList(ctx, types.ImageListOptions{})
-		// check(err)
-		// logger.Printf("docker images:")
-		// for _, img := range imgs {
-		// 	logger.Printf("%s: %d", img.RepoTags, img.Containers)
-		// }
+		// images
+		imgs, err := backend().Images()
+		check(err)
+		logger.Printf("%s images:", backend().Name())
+		for _, img := range imgs {
+			logger.Printf("%s: %s", shortId(img.ID), strings.Join(img.RepoTags, ", "))
+		}
 
-		// // containers
-		// containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
-		// check(err)
-		// logger.Printf("docker containers:")
-		// for _, container := range containers {
-		// 	logger.Printf("%s %s\n", container.ID[:10], container.Image)
-		// }
-
-		logger.Println("debug command not implemented!")
-		syscall.Exit(0)
+		// containers
+		containers, err := backend().Containers(true)
+		check(err)
+		logger.Printf("%s containers:", backend().Name())
+		for _, container := range containers {
+			logger.Printf("%s %s (%s)\n", shortId(container.ID), container.Image, container.State)
+		}
 	},
 }
 
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const displayEnvVar = "DISPLAY"
+
+// setupDisplay returns the wayland socket (empty if not used) and
+// whether X11 is used, with no usable display it sets opts.headless
+func setupDisplay() (string, bool) {
+	if opts.headless {
+		logger.Println("headless, skipping display setup")
+		return "", false
+	}
+	wayland := waylandSocket()
+	// X11 is still used under wayland by apps without wayland
+	// support (XWayland)
+	x11 := os.Getenv(displayEnvVar) != ""
+	if x11 {
+		if _, err := exec.LookPath("xauth"); err != nil {
+			logger.Printf("WARNING: %s=%s but xauth not found, skipping X11\n",
+				displayEnvVar, os.Getenv(displayEnvVar))
+			x11 = false
+		}
+	}
+	if !x11 && wayland == "" {
+		logger.Printf("neither %s nor WAYLAND_DISPLAY usable, running headless\n", displayEnvVar)
+		opts.headless = true
+	}
+	return wayland, x11
+}
+
+// waylandSocket returns the host wayland socket, or an empty
+// string if this isn't a wayland session
+func waylandSocket() string {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" {
+		return ""
+	}
+	if !filepath.IsAbs(display) {
+		runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			logger.Printf("WARNING: WAYLAND_DISPLAY=%s but XDG_RUNTIME_DIR not set, skipping wayland\n", display)
+			return ""
+		}
+		display = filepath.Join(runtimeDir, display)
+	}
+	if !isSocket(display) {
+		logger.Printf("WARNING: wayland socket %s not found, skipping wayland\n", display)
+		return ""
+	}
+	return display
+}
+
+func isSocket(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// containerRuntimeDir is XDG_RUNTIME_DIR inside the container,
+// only created if wayland is used
+func containerRuntimeDir(uid string) string {
+	return "/run/user/" + uid
+}
+
+// waylandArgs mounts the wayland socket in a runtime dir owned by the
+// container user (uid:gid), toolkits fall back to X11 (XWayland) if needed
+func waylandArgs(socket, uid, gid string) []string {
+	runtimeDir := containerRuntimeDir(uid)
+	name := filepath.Base(socket)
+	logger.Printf("wayland socket: %s\n", socket)
+	return []string{
+		fmt.Sprintf("--tmpfs=%s:rw,mode=0700,uid=%s,gid=%s", runtimeDir, uid, gid),
+		fmt.Sprintf("--volume=%s:%s/%s", socket, runtimeDir, name),
+		"--env=XDG_RUNTIME_DIR=" + runtimeDir,
+		"--env=WAYLAND_DISPLAY=" + name,
+		"--env=QT_QPA_PLATFORM=wayland;xcb",
+		"--env=GDK_BACKEND=wayland,x11",
+	}
+}
+
+// x11Args creates the xauth magic cookie file copied to the container
+// and returns the args to share the X11 socket
+func x11Args(ctx context.Context, bashCmdPath string) ([]string, error) {
+	xauthPattern := fmt.Sprintf(".%s*.xauth", appname)
+	xauthfileName := ""
+	if opts.dryRun {
+		xauthfileName = tempFilePath(xauthPattern)
+	} else {
+		xauthfile, err := os.CreateTemp(sessionDir, xauthPattern)
+		if err != nil {
+			return nil, err
+		}
+		if err := xauthfile.Close(); err != nil {
+			return nil, err
+		}
+		xauthfileName = xauthfile.Name()
+	}
+	logger.Println("temp xauth file:", xauthfileName)
+	addCopyToContainerFile(xauthfileName, "/.xauth")
+
+	xauthCmdPath, err := exec.LookPath("xauth")
+	if err != nil {
+		return nil, err
+	}
+
+	displayEnv := os.Getenv(displayEnvVar)
+	logger.Printf("env %s=%s\n", displayEnvVar, displayEnv)
+
+	xauthCmd := fmt.Sprintf("%s nlist %s | sed -e 's/^..../ffff/' | %s -f %s nmerge -",
+		xauthCmdPath, displayEnv, xauthCmdPath, xauthfileName)
+	// logger.Println("xauth cmd:", xauthCmd)
+
+	if opts.dryRun {
+		addLaunchStep("create xauth magic cookie file: "+xauthfileName, xauthCmd)
+	} else {
+		createXauthCmd := exec.CommandContext(ctx, bashCmdPath, "-c", xauthCmd)
+		if err := createXauthCmd.Run(); err != nil {
+			return nil, fmt.Errorf("create xauth file: %w", err)
+		}
+	}
+
+	return []string{
+		"--volume=/tmp/.X11-unix:/tmp/.X11-unix",
+		"--env=XAUTHORITY=/.xauth",
+		// NOTE: QT_X11_NO_MITSHM – stops Qt form using the MIT-SHM X11 extension.
+		// "--env=QT_X11_NO_MITSHM=1",
+		// "--env=QT_GRAPHICSSYSTEM=native",
+		fmt.Sprintf("--env=DISPLAY=%s", displayEnv),
+	}, nil
+}
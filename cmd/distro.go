@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// distro families, images are matched by the ID and ID_LIKE of os-release
+const (
+	familyDebian = "debian"
+	familyFedora = "fedora"
+	familyAlpine = "alpine"
+	familyArch   = "arch"
+	familySuse   = "suse"
+)
+
+var distroFamilies = map[string]string{
+	"debian":    familyDebian,
+	"ubuntu":    familyDebian,
+	"fedora":    familyFedora,
+	"rhel":      familyFedora,
+	"centos":    familyFedora,
+	"rocky":     familyFedora,
+	"almalinux": familyFedora,
+	"alpine":    familyAlpine,
+	"arch":      familyArch,
+	"suse":      familySuse,
+	"opensuse":  familySuse,
+	"sles":      familySuse,
+}
+
+// osRelease holds the fields of /etc/os-release used by dogi
+type osRelease struct {
+	ID         string
+	IDLike     []string
+	PrettyName string
+}
+
+func parseOsRelease(content string) osRelease {
+	release := osRelease{}
+	for _, line := range strings.Split(content, "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(val, `"'`)
+		switch key {
+		case "ID":
+			release.ID = strings.ToLower(val)
+		case "ID_LIKE":
+			release.IDLike = strings.Fields(strings.ToLower(val))
+		case "PRETTY_NAME":
+			release.PrettyName = val
+		}
+	}
+	return release
+}
+
+// family returns the distro family, empty if not supported
+func (r osRelease) family() string {
+	for _, id := range append([]string{r.ID}, r.IDLike...) {
+		// e.g. opensuse-leap, opensuse-tumbleweed
+		id, _, _ = strings.Cut(id, "-")
+		if family, ok := distroFamilies[id]; ok {
+			return family
+		}
+	}
+	return ""
+}
+
+// packageNames are the packages with a different name in a family
+var packageNames = map[string]map[string]string{
+	familySuse: {"tzdata": "timezone"},
+}
+
+// packageInstall returns the commands to install packages with the
+// package manager of the distro family, nil if unknown. The last one
+// installs the packages, the others refresh the package lists.
+func packageInstall(family string, packages []string) [][]string {
+	names := []string{}
+	for _, pkg := range packages {
+		if name, ok := packageNames[family][pkg]; ok {
+			pkg = name
+		}
+		names = append(names, pkg)
+	}
+	packages = names
+
+	switch family {
+	case familyDebian:
+		return [][]string{
+			{"apt-get", "-qq", "update"},
+			merge([]string{"apt-get", "-qq", "install", "apt-utils"}, packages),
+		}
+	case familyFedora:
+		// older RHEL/CentOS only have yum
+		if _, err := exec.LookPath("dnf"); err != nil {
+			return [][]string{merge([]string{"yum", "install", "-y"}, packages)}
+		}
+		return [][]string{merge([]string{"dnf", "install", "-y"}, packages)}
+	case familyAlpine:
+		return [][]string{merge([]string{"apk", "add", "--no-cache"}, packages)}
+	case familyArch:
+		// -Sy alone is a partial upgrade, unsupported by arch
+		return [][]string{
+			{"pacman", "-Syu", "--noconfirm"},
+			merge([]string{"pacman", "-S", "--noconfirm", "--needed"}, packages),
+		}
+	case familySuse:
+		return [][]string{merge([]string{"zypper", "--non-interactive", "install"}, packages)}
+	}
+	return nil
+}
+
+// readOsRelease reads the os-release of the running system (the
+// container for dogi __init)
+func readOsRelease() osRelease {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		if content, err := os.ReadFile(path); err == nil {
+			return parseOsRelease(string(content))
+		}
+	}
+	return osRelease{}
+}
+
+// imageDistro returns the distro family of an image, empty if unknown
+func imageDistro(imageName string) string {
+	meta, err := imageMetadata(imageName)
+	if err != nil {
+		logger.Printf("WARNING: unknown distro of %s: %s\n", imageName, err)
+		return ""
+	}
+	logger.Printf("image distro: %s (%s)\n", meta.DistroName, meta.Distro)
+	return meta.Distro
+}
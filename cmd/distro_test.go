@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOsRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    osRelease
+		family  string
+	}{
+		{"ubuntu",
+			"PRETTY_NAME=\"Ubuntu 22.04.4 LTS\"\nNAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\n",
+			osRelease{ID: "ubuntu", IDLike: []string{"debian"}, PrettyName: "Ubuntu 22.04.4 LTS"}, familyDebian},
+		{"rocky",
+			"NAME=\"Rocky Linux\"\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nPRETTY_NAME=\"Rocky Linux 9.3 (Blue Onyx)\"\n",
+			osRelease{ID: "rocky", IDLike: []string{"rhel", "centos", "fedora"}, PrettyName: "Rocky Linux 9.3 (Blue Onyx)"},
+			familyFedora},
+		{"opensuse",
+			"NAME=\"openSUSE Tumbleweed\"\nID=\"opensuse-tumbleweed\"\nID_LIKE=\"opensuse suse\"\n",
+			osRelease{ID: "opensuse-tumbleweed", IDLike: []string{"opensuse", "suse"}}, familySuse},
+		{"alpine",
+			"NAME=\"Alpine Linux\"\nID=alpine\nPRETTY_NAME='Alpine Linux v3.19'\n",
+			osRelease{ID: "alpine", PrettyName: "Alpine Linux v3.19"}, familyAlpine},
+		{"arch",
+			"NAME=\"Arch Linux\"\nID=arch\n  PRETTY_NAME=\"Arch Linux\"\n",
+			osRelease{ID: "arch", PrettyName: "Arch Linux"}, familyArch},
+		{"unknown",
+			"ID=nixos\n# comment\n\n",
+			osRelease{ID: "nixos"}, ""},
+		{"empty", "", osRelease{}, ""},
+	}
+	for _, test := range tests {
+		got := parseOsRelease(test.content)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseOsRelease() = %+v, want %+v", test.name, got, test.want)
+		}
+		if family := got.family(); family != test.family {
+			t.Errorf("%s: family() = %q, want %q", test.name, family, test.family)
+		}
+	}
+}
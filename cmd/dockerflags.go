@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// dockerFlags describes the options of a docker cli command,
+// flags maps each long name to whether it takes a value.
+type dockerFlags struct {
+	flags      map[string]bool
+	shorthands map[string]string
+}
+
+var dockerRunFlags = dockerFlags{
+	flags: map[string]bool{
+		"add-host": true, "annotation": true, "attach": true,
+		"blkio-weight": true, "blkio-weight-device": true,
+		"cap-add": true, "cap-drop": true, "cgroup-parent": true,
+		"cgroupns": true, "cidfile": true, "cpu-period": true,
+		"cpu-quota": true, "cpu-rt-period": true, "cpu-rt-runtime": true,
+		"cpu-shares": true, "cpus": true, "cpuset-cpus": true,
+		"cpuset-mems": true, "detach": false, "detach-keys": true,
+		"device": true, "device-cgroup-rule": true,
+		"device-read-bps": true, "device-read-iops": true,
+		"device-write-bps": true, "device-write-iops": true,
+		"disable-content-trust": false, "dns": true, "dns-option": true,
+		"dns-search": true, "domainname": true, "entrypoint": true,
+		"env": true, "env-file": true, "expose": true, "gpus": true,
+		"group-add": true, "health-cmd": true, "health-interval": true,
+		"health-retries": true, "health-start-interval": true,
+		"health-start-period": true, "health-timeout": true,
+		"hostname": true, "init": false, "interactive": false,
+		"ip": true, "ip6": true, "ipc": true, "isolation": true,
+		"kernel-memory": true, "label": true, "label-file": true,
+		"link": true, "link-local-ip": true, "log-driver": true,
+		"log-opt": true, "mac-address": true, "memory": true,
+		"memory-reservation": true, "memory-swap": true,
+		"memory-swappiness": true, "mount": true, "name": true,
+		"net": true, "net-alias": true, "network": true,
+		"network-alias": true, "no-healthcheck": false,
+		"oom-kill-disable": false, "oom-score-adj": true, "pid": true,
+		"pids-limit": true, "platform": true, "privileged": false,
+		"publish": true, "publish-all": false, "pull": true,
+		"quiet": false, "read-only": false, "restart": true, "rm": false,
+		"runtime": true, "security-opt": true, "shm-size": true,
+		"sig-proxy": false, "stop-signal": true, "stop-timeout": true,
+		"storage-opt": true, "sysctl": true, "tmpfs": true, "tty": false,
+		"ulimit": true, "use-api-socket": false, "user": true,
+		"userns": true, "uts": true, "volume": true,
+		"volume-driver": true, "volumes-from": true, "workdir": true,
+	},
+	shorthands: map[string]string{
+		"a": "attach", "c": "cpu-shares", "d": "detach", "e": "env",
+		"h": "hostname", "i": "interactive", "l": "label", "m": "memory",
+		"p": "publish", "P": "publish-all", "q": "quiet", "t": "tty",
+		"u": "user", "v": "volume", "w": "workdir",
+	},
+}
+
+var dockerExecFlags = dockerFlags{
+	flags: map[string]bool{
+		"detach": false, "detach-keys": true, "env": true,
+		"env-file": true, "interactive": false, "privileged": false,
+		"tty": false, "user": true, "workdir": true,
+	},
+	shorthands: map[string]string{
+		"d": "detach", "e": "env", "i": "interactive", "t": "tty",
+		"u": "user", "w": "workdir",
+	},
+}
+
+// docker flags given to dogi, they are appended to the docker command
+var dockerExtraArgs []string
+
+func dogiFlag(cmd *cobra.Command, name string, short bool) *pflag.Flag {
+	if short {
+		if flag := cmd.Flags().ShorthandLookup(name); flag != nil {
+			return flag
+		}
+		return cmd.InheritedFlags().ShorthandLookup(name)
+	}
+	if flag := cmd.Flags().Lookup(name); flag != nil {
+		return flag
+	}
+	return cmd.InheritedFlags().Lookup(name)
+}
+
+// bool flags have a default value when no option is given
+func takesValue(flag *pflag.Flag) bool {
+	return flag.NoOptDefVal == ""
+}
+
+// splitDockerArgs separates the docker flags from the dogi arguments,
+// anything after -- and flags unknown to both are left for dogi.
+func (d dockerFlags) splitDockerArgs(cmd *cobra.Command, args []string) (dogiArgs, dockerArgs []string) {
+	for k := 0; k < len(args); k++ {
+		arg := args[k]
+		if arg == "--" {
+			dogiArgs = append(dogiArgs, args[k:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			dogiArgs = append(dogiArgs, arg)
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--") {
+			name, _, hasValue := strings.Cut(arg[2:], "=")
+			if flag := dogiFlag(cmd, name, false); flag != nil {
+				dogiArgs = append(dogiArgs, arg)
+				if !hasValue && takesValue(flag) && k+1 < len(args) {
+					k++
+					dogiArgs = append(dogiArgs, args[k])
+				}
+			} else if _, ok := d.flags[name]; ok {
+				dockerArgs = append(dockerArgs, arg)
+				if !hasValue && d.flags[name] && k+1 < len(args) {
+					k++
+					dockerArgs = append(dockerArgs, args[k])
+				}
+			} else {
+				dogiArgs = append(dogiArgs, arg)
+			}
+			continue
+		}
+
+		// shorthands, possibly combined like -it or -p8080:80
+		if flag := dogiFlag(cmd, arg[1:2], true); flag != nil {
+			dogiArgs = append(dogiArgs, arg)
+			if len(arg) == 2 && takesValue(flag) && k+1 < len(args) {
+				k++
+				dogiArgs = append(dogiArgs, args[k])
+			}
+			continue
+		}
+		isDocker, needsValue := true, false
+		for c := 1; c < len(arg); c++ {
+			name, ok := d.shorthands[arg[c:c+1]]
+			if !ok {
+				isDocker = false
+				break
+			}
+			if d.flags[name] {
+				// the rest of the argument is the value
+				needsValue = c == len(arg)-1
+				break
+			}
+		}
+		if !isDocker {
+			dogiArgs = append(dogiArgs, arg)
+			continue
+		}
+		dockerArgs = append(dockerArgs, arg)
+		if needsValue && k+1 < len(args) {
+			k++
+			dockerArgs = append(dockerArgs, args[k])
+		}
+	}
+	return
+}
+
+// dockerArgNames returns the long names of the flags in docker args
+func (d dockerFlags) dockerArgNames(dockerArgs []string) (names []string) {
+	for k := 0; k < len(dockerArgs); k++ {
+		arg := dockerArgs[k]
+		if strings.HasPrefix(arg, "--") {
+			name, _, hasValue := strings.Cut(arg[2:], "=")
+			names = append(names, name)
+			if !hasValue && d.flags[name] {
+				k++
+			}
+			continue
+		}
+		for c := 1; c < len(arg); c++ {
+			name := d.shorthands[arg[c:c+1]]
+			names = append(names, name)
+			if d.flags[name] {
+				if c == len(arg)-1 {
+					k++
+				}
+				break
+			}
+		}
+	}
+	return
+}
+
+// dockerArgValues returns the values given to the long docker flags
+// names (e.g. network and its alias net) in docker args
+func dockerArgValues(dockerArgs []string, names ...string) (values []string) {
+	for k := 0; k < len(dockerArgs); k++ {
+		if !strings.HasPrefix(dockerArgs[k], "--") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(dockerArgs[k][2:], "=")
+		for _, wanted := range names {
+			if name != wanted {
+				continue
+			}
+			if !hasValue && k+1 < len(dockerArgs) {
+				k++
+				value = dockerArgs[k]
+			}
+			values = append(values, value)
+		}
+	}
+	return
+}
+
+// checkDockerConflicts exits if any docker flag overrides one set by dogi,
+// conflicts maps docker flag names to the reason they can't be used.
+func (d dockerFlags) checkDockerConflicts(conflicts map[string]string) {
+	failed := false
+	for _, name := range d.dockerArgNames(dockerExtraArgs) {
+		if reason, ok := conflicts[name]; ok && reason != "" {
+			logger.Printf("Error: docker flag --%s conflicts with %s: %s\n",
+				name, appname, reason)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// forwardDockerFlags removes the docker flags from the arguments of
+// commands wrapping docker, so cobra only parses dogi flags.
+func forwardDockerFlags() {
+	args, dockerArgs, ok := splitCommandArgs(os.Args[1:])
+	if !ok {
+		return
+	}
+	if len(dockerArgs) > 0 {
+		logger.Printf("forwarding docker flags: %s\n", strings.Join(dockerArgs, " "))
+	}
+	dockerExtraArgs = dockerArgs
+	rootCmd.SetArgs(args)
+}
+
+// splitCommandArgs separates the docker flags of the command wrapping
+// docker in the dogi args, ok is false for the other commands
+func splitCommandArgs(args []string) (dogiArgs, dockerArgs []string, ok bool) {
+	tables := map[*cobra.Command]dockerFlags{
+		runCmd:  dockerRunFlags,
+		execCmd: dockerExecFlags,
+	}
+	cmd, _, err := rootCmd.Find(args)
+	if err != nil {
+		return args, nil, false
+	}
+	table, ok := tables[cmd]
+	if !ok {
+		return args, nil, false
+	}
+	cmd.InitDefaultHelpFlag()
+	for k := range args {
+		if args[k] == cmd.Name() || cmd.HasAlias(args[k]) {
+			dogiArgs, dockerArgs := table.splitDockerArgs(cmd, args[k+1:])
+			return merge(args[:k+1], dogiArgs), dockerArgs, true
+		}
+	}
+	return args, nil, false
+}
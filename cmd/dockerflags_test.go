@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDockerArgValues(t *testing.T) {
+	tests := []struct {
+		args  []string
+		names []string
+		want  []string
+	}{
+		{[]string{"--network=mynet"}, []string{"network", "net"}, []string{"mynet"}},
+		{[]string{"--net", "host", "-e", "A=1"}, []string{"network", "net"}, []string{"host"}},
+		{[]string{"--network", "a", "--net=b"}, []string{"network", "net"}, []string{"a", "b"}},
+		// a prefix isn't the flag
+		{[]string{"--network-alias=x"}, []string{"network"}, nil},
+		{[]string{"-e", "network=x"}, []string{"network"}, nil},
+		// without value at the end
+		{[]string{"--network"}, []string{"network"}, []string{""}},
+	}
+	for _, test := range tests {
+		if got := dockerArgValues(test.args, test.names...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("dockerArgValues(%q, %q) = %q, want %q", test.args, test.names, got, test.want)
+		}
+	}
+}
+
+func TestSplitCommandArgs(t *testing.T) {
+	runCmd.Aliases = []string{"launch"}
+	t.Cleanup(func() { runCmd.Aliases = nil })
+	tests := []struct {
+		args, dogiArgs, dockerArgs []string
+		ok                         bool
+	}{
+		{[]string{"run", "--no-rm", "-it", "-p", "8080:80", "-e", "A=1", "--privileged",
+			"--env=B=2", "-v/a:/b", "--name", "foo", "--unknown", "ubuntu", "--", "bash", "-p", "x"},
+			[]string{"run", "--no-rm", "--privileged", "--name", "foo", "--unknown", "ubuntu", "--", "bash", "-p", "x"},
+			[]string{"-it", "-p", "8080:80", "-e", "A=1", "--env=B=2", "-v/a:/b"}, true},
+		// flags of the root command
+		{[]string{"--runtime-cli=podman", "run", "-d", "--runtime-cli", "docker", "-w", "/src", "ubuntu"},
+			[]string{"--runtime-cli=podman", "run", "--runtime-cli", "docker", "ubuntu"},
+			[]string{"-d", "-w", "/src"}, true},
+		{[]string{"exec", "-r", "-u", "root", "--workdir", "/x", "-e", "A=1"},
+			[]string{"exec", "-r", "--workdir", "/x"},
+			[]string{"-u", "root", "-e", "A=1"}, true},
+		{[]string{"launch", "-p", "1:1", "ubuntu"},
+			[]string{"launch", "ubuntu"}, []string{"-p", "1:1"}, true},
+		{[]string{"ls", "--json"}, []string{"ls", "--json"}, nil, false},
+	}
+	for _, test := range tests {
+		dogiArgs, dockerArgs, ok := splitCommandArgs(test.args)
+		if ok != test.ok || !reflect.DeepEqual(dogiArgs, test.dogiArgs) ||
+			!reflect.DeepEqual(dockerArgs, test.dockerArgs) {
+			t.Errorf("splitCommandArgs(%q) = %q, %q, %t, want %q, %q, %t", test.args,
+				dogiArgs, dockerArgs, ok, test.dogiArgs, test.dockerArgs, test.ok)
+		}
+	}
+}
+
+func TestRunFlagConflicts(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	tests := []struct {
+		noRM, noNethost bool
+		dockerArgs      []string
+		want            []string
+	}{
+		{false, false, []string{"-it", "--rm", "-p", "80:80"}, []string{"interactive", "tty", "rm"}},
+		{true, false, []string{"--rm"}, []string{"rm"}},
+		{true, false, []string{"--network=mynet", "-e", "A=1"}, []string{"network"}},
+		{false, true, []string{"--net", "mynet", "-d"}, []string{"detach"}},
+		{false, true, []string{"-u", "root", "-w", "/src"}, []string{"user", "workdir"}},
+	}
+	for _, test := range tests {
+		opts.noRM, opts.noNethost = test.noRM, test.noNethost
+		conflicts := runFlagConflicts()
+		got := []string{}
+		for _, name := range dockerRunFlags.dockerArgNames(test.dockerArgs) {
+			if conflicts[name] != "" {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("conflicts of %q (no-rm %t, no-nethost %t) = %q, want %q",
+				test.dockerArgs, test.noRM, test.noNethost, got, test.want)
+		}
+	}
+}
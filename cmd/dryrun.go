@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// launchStep is a side effect of dogi run written as shell,
+// with --dry-run steps are printed instead of executed.
+type launchStep struct {
+	comment string
+	shell   string
+}
+
+var launchSteps []launchStep
+
+func addLaunchStep(comment, shell string) {
+	launchSteps = append(launchSteps, launchStep{comment: comment, shell: shell})
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_/.:=,@%+-]+$`)
+
+// shellQuote joins args quoting the ones that need it
+func shellQuote(args ...string) string {
+	quoted := make([]string, len(args))
+	for k, arg := range args {
+		if shellSafe.MatchString(arg) {
+			quoted[k] = arg
+		} else {
+			quoted[k] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// heredoc feeds content to the stdin of a shell command
+func heredoc(command, content string) string {
+	const eof = "DOGI_EOF"
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return fmt.Sprintf("%s <<'%s'\n%s%s", command, eof, content, eof)
+}
+
+// tempFilePath returns a path in the session dir following the
+// os.CreateTemp pattern, without creating it.
+func tempFilePath(pattern string) string {
+	return filepath.Join(sessionDir,
+		strings.Replace(pattern, "*", fmt.Sprint(rand.Uint32()), 1))
+}
+
+// writeTempFile creates a temp file with content and returns its path,
+// with --dry-run it is only recorded as a launch step.
+func writeTempFile(pattern, content string) string {
+	if opts.dryRun {
+		path := tempFilePath(pattern)
+		addLaunchStep("create "+path, heredoc("cat > "+shellQuote(path), content))
+		return path
+	}
+	file, err := os.CreateTemp(sessionDir, pattern)
+	check(err)
+	defer file.Close()
+	_, err = file.WriteString(content)
+	check(err)
+	return file.Name()
+}
+
+// printLaunchSteps shows what dogi run would do, as a readable
+// summary or as a standalone bash script (--print).
+func printLaunchSteps() {
+	if opts.printScript {
+		fmt.Println("#!/usr/bin/env bash")
+		fmt.Printf("# generated by: %s\n", shellQuote(os.Args...))
+		fmt.Println("set -e")
+		for _, step := range launchSteps {
+			fmt.Printf("\n# %s\n%s\n", step.comment, step.shell)
+		}
+		return
+	}
+
+	logger.Println("dry run, nothing was executed")
+	logger.Println("these are the steps to launch the container:")
+	for k, step := range launchSteps {
+		fmt.Printf("\n%s %s\n", Blue(fmt.Sprintf("%d.", k+1)), step.comment)
+		for _, line := range strings.Split(step.shell, "\n") {
+			fmt.Println("    " + line)
+		}
+	}
+	fmt.Println()
+	logger.Printf("use %s to get them as a bash script\n", Gray("--print"))
+}
@@ -2,9 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"os/user"
 	"strings"
 	"syscall"
 
@@ -28,35 +25,56 @@ const execExamples = `
     {{.appname}} exec -- make -C ~/myrepository/build
 
     {{.appname}} exec <container-name> -- make -C ~/myrepository/build
+
+  - Pass any docker exec flag, it will be forwarded to docker
+
+    {{.appname}} exec --env FOO=bar <container-name>
+
+  - Pipe the output of a command, without a terminal --tty is not used
+    and the exit code is the command one
+
+    {{.appname}} exec -r -- make | tee build.log
 `
 
-func userContainer(contName string) bool {
-	out, err := exec.Command("docker", "container",
-		"inspect", "-f", "{{ .Args  }}", contName).Output()
+// userContainer returns the user a dogi container was launched for,
+// or an empty string if it runs as root or wasn't launched by dogi
+func userContainer(contName string) string {
+	info, err := backend().InspectContainer(contName)
 	check(err)
-	return strings.Contains(string(out), appname)
+	if mode, ok := info.Config.Labels[labelUserMode]; ok {
+		if mode != "true" {
+			return ""
+		}
+		return info.Config.Labels[labelUser]
+	}
+	// without labels (older dogi versions) the user was set up by the
+	// dogi entrypoint, podman keep-id containers run as the user directly
+	if strings.Contains(strings.Join(info.Args, " "), appname) ||
+		(rootlessPodman() && strings.HasPrefix(info.Config.User, userSingleton().Uid+":")) {
+		return userSingleton().Username
+	}
+	return ""
 }
 
-func dockerPs() []string {
-	out, err := exec.Command("docker", "ps").Output()
+func dockerPs() []containerSummary {
+	containers, err := backend().Containers(false)
 	check(err)
 
-	options := strings.Split(
-		strings.TrimSpace(string(out[:])), "\n")
-	if len(options) == 0 {
+	if len(containers) == 0 {
 		fmt.Printf("Error: no containers running?\n")
 		syscall.Exit(1)
 	}
 
-	return options
+	return containers
 }
 
 func recentContainer() string {
-	return strings.Fields(dockerPs()[1])[0]
+	return shortId(dockerPs()[0].ID)
 }
 
 func selectContainer() string {
-	options := dockerPs()
+	checkTerminal("a container")
+	options := containerLines(dockerPs())
 	result := ""
 	prompt := &survey.Select{
 		Message: "Select container:\n  " + options[0] + "\n",
@@ -89,6 +107,8 @@ Examples:
 `, map[string]string{"execExamples": execExamples}),
 		PreRun: func(cmd *cobra.Command, args []string) {
 			only1Arg(cmd, args, "container")
+			loadConfig(cmd)
+			setupTerminal()
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			// logger.Println("len(args):", len(args))
@@ -97,7 +117,7 @@ Examples:
 			contName := ""
 			beforeArgs := beforeDashArgs(cmd, args)
 			if len(beforeArgs) == 0 {
-				if !recentCtrPtr {
+				if !opts.recentCtr {
 					contName = selectContainer()
 				} else {
 					logger.Printf("use most recent container (--recent provided)\n")
@@ -109,34 +129,43 @@ Examples:
 			}
 			logger.Printf("contName: %s\n", contName)
 
-			if !noUserPtr {
-				if userContainer(contName) {
-					userObj, err := user.Current()
-					check(err)
-					logger.Println("username:", userObj.Username)
+			if !opts.noUser {
+				if username := userContainer(contName); username != "" {
+					logger.Println("username:", username)
 					dockerRunArgs = append(dockerRunArgs,
-						fmt.Sprintf("--user=%s", userObj.Username))
+						fmt.Sprintf("--user=%s", username))
 				} else {
 					logger.Println("WARNING: container launched as root, won't use current user")
 				}
 			}
 			if !workDirProvided() {
 				// try to use the same workdir as when container was launched
-				out, err := exec.Command("docker", "container",
-					"inspect", "-f", "{{ .Config.WorkingDir }}", contName).Output()
+				info, err := backend().InspectContainer(contName)
 				if err != nil {
 					logger.Fatalf("container '%s' not available?", contName)
 				}
-				wd := strings.TrimSpace(string(out[:]))
+				wd := info.Config.WorkingDir
 				if wd != "" {
-					workDirPtr = wd
+					opts.workDir = wd
 				} else {
 					// TODO: what should be default exec working dir? maybe ask?
-					workDirPtr = "/"
+					opts.workDir = "/"
 				}
 			}
-			logger.Printf("workdir: %s\n", workDirPtr)
-			dockerRunArgs = append(dockerRunArgs, fmt.Sprintf("--workdir=%s", workDirPtr))
+			logger.Printf("workdir: %s\n", opts.workDir)
+			dockerRunArgs = append(dockerRunArgs, fmt.Sprintf("--workdir=%s", opts.workDir))
+
+			conflicts := map[string]string{
+				"interactive": "always set by " + appname,
+				"tty":         "always set by " + appname,
+				"detach":      appname + " attaches to the container",
+				"workdir":     "use --workdir instead",
+			}
+			if !opts.noUser {
+				conflicts["user"] = appname + " uses your user, or root with --no-user"
+			}
+			dockerExecFlags.checkDockerConflicts(conflicts)
+			dockerRunArgs = append(dockerRunArgs, dockerExtraArgs...)
 
 			logger.Printf("docker args: %s\n", dockerRunArgs)
 
@@ -149,21 +178,19 @@ Examples:
 				entrypoint = append(entrypoint, "bash")
 			}
 			logger.Printf("entrypoint: %s\n", entrypoint)
-			dockerArgs := merge([]string{dockerCmd, cmd.CalledAs()},
-				dockerRunArgs,
-				entrypoint)
+			dockerArgs := backend().ExecArgs(merge(dockerRunArgs, entrypoint))
 			logger.Println("docker command: ", strings.Join(merge(dockerArgs), " "))
 
-			announceEnteringContainer()
-			// syscall exec is used to replace the current process
-			check(syscall.Exec(dockerBinPath(), dockerArgs, os.Environ()))
+			enterContainer(dockerArgs, "exec", nil)
 		},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(execCmd)
-	execCmd.Flags().BoolVar(&noUserPtr, "no-user", false, "don't use user inside container (run as root inside)")
-	execCmd.Flags().BoolVarP(&recentCtrPtr, "recent", "r", false, "use the most recent container")
-	execCmd.Flags().StringVar(&workDirPtr, "workdir", "", "working directory inside the container")
+	configCommands = append(configCommands, execCmd)
+	execCmd.Flags().BoolVar(&opts.noUser, "no-user", false, "don't use user inside container (run as root inside)")
+	execCmd.Flags().BoolVarP(&opts.recentCtr, "recent", "r", false, "use the most recent container")
+	execCmd.Flags().StringVar(&opts.workDir, "workdir", "", "working directory inside the container")
+	execCmd.Flags().BoolVar(&opts.supervise, "supervise", false, "keep dogi running while in the container (instead of replacing it by docker) to forward signals and report the exit code")
 }
@@ -0,0 +1,31 @@
+package cmd
+
+import "testing"
+
+func TestUserContainer(t *testing.T) {
+	withLabels := func(labels map[string]string, args ...string) containerInfo {
+		info := containerInfo{Args: args}
+		info.Config.Labels = labels
+		return info
+	}
+	setTestBackend(t, &fakeBackend{infos: map[string]containerInfo{
+		"user":  withLabels(map[string]string{labelUserMode: "true", labelUser: "me"}),
+		"root":  withLabels(map[string]string{labelUserMode: "false", labelUser: "me"}, "/dogi", "__init"),
+		"older": withLabels(nil, "/dogi", "__init", "--user=me", "--", "bash"),
+		"other": withLabels(map[string]string{"maintainer": "someone"}, "nginx", "-g", "daemon off;"),
+	}})
+	tests := []struct {
+		cont, want string
+	}{
+		{"user", "me"},
+		{"root", ""},
+		// launched by dogi before the labels, for the current user
+		{"older", userSingleton().Username},
+		{"other", ""},
+	}
+	for _, test := range tests {
+		if got := userContainer(test.cont); got != test.want {
+			t.Errorf("userContainer(%s) = %q, want %q", test.cont, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imageMeta is what dogi needs to know about an image. It's cached on
+// disk by image ID (images are immutable), so the os-release of an
+// image is only read once.
+type imageMeta struct {
+	// entries of older versions are read again
+	Version    int      `json:"version"`
+	ID         string   `json:"id"`
+	Distro     string   `json:"distro"`
+	DistroName string   `json:"distroName"`
+	User       string   `json:"user"`
+	Cmd        []string `json:"cmd"`
+	Entrypoint []string `json:"entrypoint"`
+	Env        []string `json:"env"`
+	WorkingDir string   `json:"workingDir"`
+	// dnf.conf or yum.conf of fedora images, the package cache proxy
+	// is set in it
+	PackageConfPath string `json:"packageConfPath,omitempty"`
+	PackageConf     string `json:"packageConf,omitempty"`
+}
+
+const imageCacheVersion = 2
+
+// env returns the value of an environment variable of the image
+func (m imageMeta) env(name string) string {
+	for _, varStr := range m.Env {
+		if envVar, val, _ := strings.Cut(varStr, "="); envVar == name {
+			return val
+		}
+	}
+	return ""
+}
+
+// images already looked up by this process, by name
+var imageMetas = map[string]imageMeta{}
+
+func imageCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appname, "images"), nil
+}
+
+func readImageCache(id string) (imageMeta, bool) {
+	dir, err := imageCacheDir()
+	if err != nil {
+		return imageMeta{}, false
+	}
+	content, err := os.ReadFile(filepath.Join(dir, shortId(id)+".json"))
+	if err != nil {
+		return imageMeta{}, false
+	}
+	meta := imageMeta{}
+	if err := json.Unmarshal(content, &meta); err != nil || meta.ID != id ||
+		meta.Version != imageCacheVersion {
+		return imageMeta{}, false
+	}
+	return meta, true
+}
+
+func writeImageCache(meta imageMeta) error {
+	dir, err := imageCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, shortId(meta.ID)+".json"), content, 0644)
+}
+
+// readImageOsRelease reads /etc/os-release of an image, or the
+// /usr/lib/os-release it usually links to
+func readImageOsRelease(image string) ([]byte, error) {
+	out, err := backend().ReadImageFile(image, "/etc/os-release")
+	if err == nil {
+		return out, nil
+	}
+	if out, libErr := backend().ReadImageFile(image, "/usr/lib/os-release"); libErr == nil {
+		return out, nil
+	}
+	return nil, err
+}
+
+// readImagePackageConf reads the dnf config of a fedora image,
+// older RHEL/CentOS only have yum
+func readImagePackageConf(meta *imageMeta) error {
+	for _, path := range []string{"/etc/dnf/dnf.conf", "/etc/yum.conf"} {
+		if out, err := backend().ReadImageFile(meta.ID, path); err == nil {
+			meta.PackageConfPath, meta.PackageConf = path, string(out)
+			return nil
+		}
+	}
+	return fmt.Errorf("neither /etc/dnf/dnf.conf nor /etc/yum.conf found in %s", shortId(meta.ID))
+}
+
+// imageMetadata inspects an image (once per process) and returns its
+// metadata, reading its os-release only if it isn't cached. Failed
+// reads aren't cached (e.g. the daemon failed), they are retried by
+// the next launch.
+func imageMetadata(imageName string) (imageMeta, error) {
+	if meta, ok := imageMetas[imageName]; ok {
+		return meta, nil
+	}
+	info, err := backend().InspectImage(imageName)
+	if err != nil {
+		return imageMeta{}, err
+	}
+
+	meta, ok := readImageCache(info.ID)
+	if !ok {
+		meta = imageMeta{
+			Version:    imageCacheVersion,
+			ID:         info.ID,
+			User:       info.Config.User,
+			Cmd:        info.Config.Cmd,
+			Entrypoint: info.Config.Entrypoint,
+			Env:        info.Config.Env,
+			WorkingDir: info.Config.WorkingDir,
+		}
+		cache := false
+		if out, err := readImageOsRelease(info.ID); err != nil {
+			logger.Printf("WARNING: can't read the os-release of %s, unknown distro: %s\n",
+				imageName, err)
+		} else {
+			release := parseOsRelease(string(out))
+			meta.Distro, meta.DistroName = release.family(), release.PrettyName
+			cache = true
+			// not found in minimal images, the package cache isn't used
+			if meta.Distro == familyFedora {
+				if err := readImagePackageConf(&meta); err != nil {
+					logger.Println(err)
+				}
+			}
+		}
+		// --dry-run doesn't write anything
+		if cache && !opts.dryRun {
+			if err := writeImageCache(meta); err != nil {
+				logger.Printf("WARNING: failed to cache image metadata: %s\n", err)
+			}
+		}
+	}
+	imageMetas[imageName] = meta
+	return meta, nil
+}
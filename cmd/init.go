@@ -0,0 +1,533 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+const (
+	initCmdName = "__init"
+	// where dogi run copies the dogi binary
+	dogiContainerPath = "/usr/bin/" + appname
+)
+
+// initOptions is the host user to create inside the container
+type initOptions struct {
+	username string
+	uid      string
+	gid      string
+	home     string
+	name     string
+	// host groups as name:gid
+	groups []string
+	// only set up the user, used by dogi build
+	setupOnly bool
+	// don't install sudo and friends if missing
+	noTools bool
+}
+
+var initOpts initOptions
+
+// initFlags are the dogi __init flags to set up the host user
+func initFlags(userObj *userSingletonType) []string {
+	flags := []string{
+		"--user=" + userObj.Username,
+		"--uid=" + userObj.Uid,
+		"--gid=" + userObj.Gid,
+		"--home=" + userObj.HomeDir,
+		"--name=" + userObj.Name,
+	}
+	groups, err := userObj.containerGroups()
+	check(err)
+	for _, group := range groups {
+		flags = append(flags, "--group="+group)
+	}
+	if opts.noTools {
+		flags = append(flags, "--no-tools")
+	}
+	return flags
+}
+
+// initArgs returns the container entrypoint that sets up the user
+// and runs command as this user
+func initArgs(userObj *userSingletonType, command []string) []string {
+	return merge([]string{dogiContainerPath, initCmdName}, initFlags(userObj),
+		[]string{"--"}, command)
+}
+
+// dbFile is a colon separated file like /etc/passwd or /etc/group
+type dbFile struct {
+	path    string
+	entries [][]string
+}
+
+func readDbFile(path string) (*dbFile, error) {
+	db := &dbFile{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			db.entries = append(db.entries, strings.Split(line, ":"))
+		}
+	}
+	return db, scanner.Err()
+}
+
+// find returns the first entry with value in the field index
+func (db *dbFile) find(field int, value string) []string {
+	for _, entry := range db.entries {
+		if len(entry) > field && entry[field] == value {
+			return entry
+		}
+	}
+	return nil
+}
+
+// remove deletes the entries with value in the field index
+func (db *dbFile) remove(field int, value string) {
+	entries := [][]string{}
+	for _, entry := range db.entries {
+		if len(entry) <= field || entry[field] != value {
+			entries = append(entries, entry)
+		}
+	}
+	db.entries = entries
+}
+
+func (db *dbFile) write(mode os.FileMode) error {
+	if info, err := os.Stat(db.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	var content strings.Builder
+	for _, entry := range db.entries {
+		content.WriteString(strings.Join(entry, ":") + "\n")
+	}
+	return os.WriteFile(db.path, []byte(content.String()), mode)
+}
+
+// addGroup makes sure there's a group with gid (named name if available)
+// and returns its name
+func addGroup(groups *dbFile, name, gid string) string {
+	if group := groups.find(2, gid); group != nil {
+		return group[0]
+	}
+	if groups.find(0, name) != nil {
+		logger.Printf("group %s exists inside container with another gid, using %s_host for gid %s\n",
+			name, name, gid)
+		name += "_host"
+	}
+	groups.entries = append(groups.entries, []string{name, "x", gid, ""})
+	return name
+}
+
+func addGroupMember(groups *dbFile, gid, username string) {
+	for k, group := range groups.entries {
+		if len(group) < 3 || group[2] != gid {
+			continue
+		}
+		for len(group) < 4 {
+			group = append(group, "")
+		}
+		members := []string{}
+		if group[3] != "" {
+			members = strings.Split(group[3], ",")
+		}
+		for _, member := range members {
+			if member == username {
+				return
+			}
+		}
+		group[3] = strings.Join(append(members, username), ",")
+		groups.entries[k] = group
+		return
+	}
+}
+
+// etcDir has the user databases edited by createUser
+var etcDir = "/etc"
+
+// createUser adds the user and its groups editing /etc/passwd,
+// /etc/group and /etc/shadow, and returns the supplementary gids
+func createUser(o initOptions) ([]uint32, error) {
+	groups, err := readDbFile(filepath.Join(etcDir, "group"))
+	if err != nil {
+		return nil, err
+	}
+	addGroup(groups, o.username, o.gid)
+	gids := []uint32{}
+	for _, group := range o.groups {
+		name, gid, ok := strings.Cut(group, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid group '%s', expected name:gid", group)
+		}
+		gid32, err := strconv.ParseUint(gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("  - group %s (gid %s)\n", addGroup(groups, name, gid), gid)
+		addGroupMember(groups, gid, o.username)
+		gids = append(gids, uint32(gid32))
+	}
+	if err := groups.write(0644); err != nil {
+		return nil, err
+	}
+
+	passwd, err := readDbFile(filepath.Join(etcDir, "passwd"))
+	if err != nil {
+		return nil, err
+	}
+	if existing := passwd.find(2, o.uid); existing != nil && existing[0] != o.username {
+		logger.Printf("WARNING: host uid (%s) exists inside container (as %s), replacing it by %s\n",
+			o.uid, existing[0], o.username)
+	}
+	passwd.remove(2, o.uid)
+	passwd.remove(0, o.username)
+	shell := "/bin/sh"
+	if _, err := os.Stat("/bin/bash"); err == nil {
+		shell = "/bin/bash"
+	}
+	gecos := strings.NewReplacer(":", " ", "\n", " ").Replace(o.name)
+	passwd.entries = append(passwd.entries,
+		[]string{o.username, "x", o.uid, o.gid, gecos, o.home, shell})
+	if err := passwd.write(0644); err != nil {
+		return nil, err
+	}
+
+	// not every image has /etc/shadow
+	if _, err := os.Stat(filepath.Join(etcDir, "shadow")); err == nil {
+		shadow, err := readDbFile(filepath.Join(etcDir, "shadow"))
+		if err != nil {
+			return nil, err
+		}
+		shadow.remove(0, o.username)
+		days := strconv.FormatInt(time.Now().Unix()/(24*60*60), 10)
+		shadow.entries = append(shadow.entries,
+			[]string{o.username, "!", days, "0", "99999", "7", "", "", ""})
+		if err := shadow.write(0640); err != nil {
+			return nil, err
+		}
+	}
+	return gids, nil
+}
+
+// copySkel copies /etc/skel to the home dir, without overwriting files
+func copySkel(home string, uid, gid int) error {
+	const skel = "/etc/skel"
+	if _, err := os.Stat(skel); err != nil {
+		return nil
+	}
+	return filepath.Walk(skel, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(skel, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		dst := filepath.Join(home, rel)
+		if _, err := os.Lstat(dst); err == nil {
+			return nil
+		}
+		switch {
+		case info.IsDir():
+			err = os.Mkdir(dst, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			var target string
+			if target, err = os.Readlink(path); err == nil {
+				err = os.Symlink(target, dst)
+			}
+		default:
+			var content []byte
+			if content, err = os.ReadFile(path); err == nil {
+				err = os.WriteFile(dst, content, info.Mode().Perm())
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return os.Lchown(dst, uid, gid)
+	})
+}
+
+func appendToFile(path, content string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(content)
+	return err
+}
+
+// setupHome creates the home dir of the user
+func setupHome(o initOptions, uid, gid int) error {
+	logger.Printf("- create homedir: %s\n", o.home)
+	if err := os.MkdirAll(o.home, 0755); err != nil {
+		return err
+	}
+	bashrc := filepath.Join(o.home, ".bashrc")
+	_, err := os.Stat(bashrc)
+	newBashrc := os.IsNotExist(err)
+	if err := copySkel(o.home, uid, gid); err != nil {
+		return err
+	}
+	if _, err := os.Stat("/bin/bash"); newBashrc && err == nil {
+		if err := appendToFile(bashrc, fmt.Sprintf("PS1=\"🐳 ${PS1}\"\nPATH=\"%s/.local/bin:%s/bin:$PATH\"\n",
+			o.home, o.home)); err != nil {
+			return err
+		}
+		check(os.Chown(bashrc, uid, gid))
+		// for after sudo -s
+		if _, err := os.Stat("/root"); err == nil {
+			_ = appendToFile("/root/.bashrc", "PS1=\"🐳 ${PS1}\"\n")
+		}
+	}
+
+	// some entries are read-only mounts (e.g. .ssh), chown what's possible
+	entries, err := os.ReadDir(o.home)
+	if err != nil {
+		return err
+	}
+	_ = os.Chown(o.home, uid, gid)
+	for _, entry := range entries {
+		_ = os.Lchown(filepath.Join(o.home, entry.Name()), uid, gid)
+	}
+	return filepath.Walk(filepath.Join(o.home, ".cache"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// packageChecks are the packages installed for convenience if missing
+var packageChecks = map[string]string{
+	"sudo":            "/usr/bin/sudo",
+	"vim":             "/usr/bin/vim",
+	"tzdata":          "/usr/share/zoneinfo",
+	"bash-completion": "/usr/share/bash-completion",
+}
+
+// installPackages installs sudo and friends if missing (not with --no-tools),
+// failures aren't fatal since the user is set up without them
+func installPackages() {
+	missing := []string{}
+	for pkg, path := range packageChecks {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	family := readOsRelease().family()
+	commands := packageInstall(family, missing)
+	if commands == nil {
+		logger.Printf("- unknown distro, won't install %s\n", strings.Join(missing, " "))
+		return
+	}
+
+	logger.Printf("- installing %s...\n", strings.Join(missing, ", "))
+	run := func(args []string) error {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			logger.Printf("WARNING: %s failed: %s\n%s", strings.Join(args, " "), err, out)
+		}
+		return err
+	}
+	for _, args := range commands[:len(commands)-1] {
+		if run(args) != nil {
+			return
+		}
+	}
+	if run(commands[len(commands)-1]) == nil || len(missing) == 1 {
+		return
+	}
+	// a package missing in the distro fails the whole install,
+	// the others (e.g. sudo) can still be installed
+	logger.Println("- installing them one by one...")
+	for _, pkg := range missing {
+		install := packageInstall(family, []string{pkg})
+		_ = run(install[len(install)-1])
+	}
+}
+
+// setupSudo lets the user use sudo without password, if available
+func setupSudo(username string) {
+	if _, err := exec.LookPath("sudo"); err != nil {
+		logger.Println("- sudo not available")
+		return
+	}
+	if err := os.MkdirAll("/etc/sudoers.d", 0755); err != nil {
+		logger.Printf("WARNING: %s\n", err)
+		return
+	}
+	// !secure_path keeps the PATH of the user
+	if err := os.WriteFile("/etc/sudoers.d/"+appname,
+		[]byte(fmt.Sprintf("Defaults:%s !secure_path\n%s ALL=NOPASSWD: ALL\n",
+			username, username)), 0440); err != nil {
+		logger.Printf("WARNING: %s\n", err)
+	}
+}
+
+// runAsUser starts command as uid:gid and waits for it like an init
+// process: forwarding signals and reaping zombies, it returns the exit
+// code of the command
+func runAsUser(o initOptions, gids []uint32, command []string) (int, error) {
+	uid, err := strconv.ParseUint(o.uid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(o.gid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if len(command) == 0 {
+		command = []string{"bash"}
+	}
+
+	env := []string{}
+	for _, val := range os.Environ() {
+		switch strings.SplitN(val, "=", 2)[0] {
+		case "HOME", "USER", "LOGNAME":
+		default:
+			env = append(env, val)
+		}
+	}
+	env = append(env, "HOME="+o.home, "USER="+o.username, "LOGNAME="+o.username)
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return 127, err
+	}
+	cmd := exec.Command(path, command[1:]...)
+	cmd.Args[0] = command[0]
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: gids},
+		Setpgid:    true,
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		// the command gets the terminal signals (ctrl+c) directly
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+		signal.Ignore(syscall.SIGTTIN, syscall.SIGTTOU)
+	}
+
+	if os.Getpid() != 1 {
+		// orphans are reparented to us instead of the real init
+		_ = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+	}
+	sigs := make(chan os.Signal, 10)
+	signal.Notify(sigs)
+	if err := cmd.Start(); err != nil {
+		return 127, err
+	}
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGCHLD, syscall.SIGURG, syscall.SIGTTIN, syscall.SIGTTOU:
+			default:
+				_ = syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+			}
+		}
+	}()
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if pid != cmd.Process.Pid {
+			// a zombie
+			continue
+		}
+		if status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return status.ExitStatus(), nil
+	}
+}
+
+func containerInit(o initOptions, command []string) int {
+	logger.SetOutput(os.Stderr)
+	if release := readOsRelease(); release.PrettyName != "" {
+		logger.Printf("- container image OS: %s\n", release.PrettyName)
+	}
+
+	logger.Printf("- creating user %s...\n", o.username)
+	gids, err := createUser(o)
+	check(err)
+	uid, err := strconv.Atoi(o.uid)
+	check(err)
+	gid, err := strconv.Atoi(o.gid)
+	check(err)
+	check(setupHome(o, uid, gid))
+	check(os.MkdirAll("/usr/local/bin", 0755))
+	check(os.WriteFile("/usr/local/bin/matrix",
+		[]byte("#!/bin/sh\nexec "+appname+" \"$@\"\n"), 0755))
+	if !o.noTools {
+		installPackages()
+	}
+	setupSudo(o.username)
+	if o.setupOnly {
+		return 0
+	}
+
+	logger.Println("- done, happy 🐳!")
+	logger.Printf("- run as user %s: %s\n", o.username, strings.Join(command, " "))
+	code, err := runAsUser(o, gids, command)
+	if err != nil {
+		logger.Printf("Error: %s\n", err)
+	}
+	return code
+}
+
+var initCmd = &cobra.Command{
+	Use:    initCmdName + " [flags] -- [command]",
+	Short:  "Set up the user inside a dogi container and run the command (used by dogi run)",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(containerInit(initOpts, args))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringVar(&initOpts.username, "user", "", "user name")
+	initCmd.Flags().StringVar(&initOpts.uid, "uid", "", "user id")
+	initCmd.Flags().StringVar(&initOpts.gid, "gid", "", "user group id")
+	initCmd.Flags().StringVar(&initOpts.home, "home", "", "home directory")
+	initCmd.Flags().StringVar(&initOpts.name, "name", "", "full name of the user")
+	initCmd.Flags().StringArrayVar(&initOpts.groups, "group", nil, "supplementary group as name:gid")
+	initCmd.Flags().BoolVar(&initOpts.setupOnly, "setup-only", false, "set up the user without running any command")
+	initCmd.Flags().BoolVar(&initOpts.noTools, "no-tools", false, "don't install sudo, vim, tzdata and bash-completion if missing")
+	for _, name := range []string{"user", "uid", "gid", "home"} {
+		check(initCmd.MarkFlagRequired(name))
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEtc makes createUser edit the files of a temp dir
+func testEtc(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := etcDir
+	etcDir = dir
+	t.Cleanup(func() { etcDir = previous })
+	return dir
+}
+
+func readEtc(t *testing.T, dir, name string) []string {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestCreateUser(t *testing.T) {
+	dir := testEtc(t, map[string]string{
+		"passwd": "root:x:0:0:root:/root:/bin/bash\nubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash\n",
+		"group":  "root:x:0:\nvideo:x:44:\ndocker:x:999:\nubuntu:x:1000:\n",
+		"shadow": "root:*:19000:0:99999:7:::\nubuntu:!:19000:0:99999:7:::\n",
+	})
+	shell := "/bin/sh"
+	if _, err := os.Stat("/bin/bash"); err == nil {
+		shell = "/bin/bash"
+	}
+	o := initOptions{username: "me", uid: "1000", gid: "1000", home: "/home/me",
+		name: "Me: Myself", groups: []string{"video:44", "docker:998"}}
+
+	// run twice, like a container started again
+	for range 2 {
+		gids, err := createUser(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gids, []uint32{44, 998}) {
+			t.Errorf("gids = %v", gids)
+		}
+	}
+
+	// the image user with the same uid is replaced
+	if passwd, want := readEtc(t, dir, "passwd"), []string{
+		"root:x:0:0:root:/root:/bin/bash",
+		"me:x:1000:1000:Me  Myself:/home/me:" + shell,
+	}; !reflect.DeepEqual(passwd, want) {
+		t.Errorf("passwd = %q, want %q", passwd, want)
+	}
+	// the group of the gid is kept, docker is taken by another gid
+	if group, want := readEtc(t, dir, "group"), []string{
+		"root:x:0:",
+		"video:x:44:me",
+		"docker:x:999:",
+		"ubuntu:x:1000:",
+		"docker_host:x:998:me",
+	}; !reflect.DeepEqual(group, want) {
+		t.Errorf("group = %q, want %q", group, want)
+	}
+	shadow := readEtc(t, dir, "shadow")
+	if len(shadow) != 3 || !strings.HasPrefix(shadow[2], "me:!:") || shadow[1] != "ubuntu:!:19000:0:99999:7:::" {
+		t.Errorf("shadow = %q", shadow)
+	}
+}
+
+func TestCreateUserMinimalImage(t *testing.T) {
+	// e.g. distroless: no group nor shadow
+	dir := testEtc(t, map[string]string{"passwd": "root:x:0:0:root:/root:/sbin/nologin\n"})
+	if _, err := createUser(initOptions{username: "me", uid: "1001", gid: "1002", home: "/home/me"}); err != nil {
+		t.Fatal(err)
+	}
+	if group := readEtc(t, dir, "group"); !reflect.DeepEqual(group, []string{"me:x:1002:"}) {
+		t.Errorf("group = %q", group)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shadow")); !os.IsNotExist(err) {
+		t.Errorf("shadow created: %v", err)
+	}
+}
+
+func TestCreateUserInvalidGroup(t *testing.T) {
+	testEtc(t, nil)
+	for _, group := range []string{"video", "video:abc"} {
+		if _, err := createUser(initOptions{username: "me", uid: "1000", gid: "1000",
+			groups: []string{group}}); err == nil {
+			t.Errorf("group %q accepted", group)
+		}
+	}
+}
+
+func TestAddGroup(t *testing.T) {
+	groups := &dbFile{entries: [][]string{{"audio", "x", "29", ""}, {"users", "x", "100"}}}
+	tests := []struct {
+		name, gid, want string
+	}{
+		{"sound", "29", "audio"},
+		{"audio", "63", "audio_host"},
+		{"render", "109", "render"},
+		{"render", "109", "render"},
+	}
+	for _, test := range tests {
+		if got := addGroup(groups, test.name, test.gid); got != test.want {
+			t.Errorf("addGroup(%s, %s) = %s, want %s", test.name, test.gid, got, test.want)
+		}
+	}
+	if len(groups.entries) != 4 {
+		t.Errorf("groups = %q", groups.entries)
+	}
+
+	// a group without members field
+	addGroupMember(groups, "100", "me")
+	addGroupMember(groups, "100", "me")
+	addGroupMember(groups, "29", "me")
+	if got := groups.find(2, "100"); !reflect.DeepEqual(got, []string{"users", "x", "100", "me"}) {
+		t.Errorf("users = %q", got)
+	}
+}
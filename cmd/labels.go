@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// labels stamped on every container launched by dogi
+const (
+	labelVersion  = appname + ".version"
+	labelUser     = appname + ".user"
+	labelUid      = appname + ".uid"
+	labelWorkdir  = appname + ".workdir"
+	labelImage    = appname + ".image"
+	labelUserMode = appname + ".user-mode"
+	labelLaunched = appname + ".launched"
+	labelConfig   = appname + ".config"
+	// images built by dogi build
+	labelGid       = appname + ".gid"
+	labelBaseImage = appname + ".base-image"
+	labelBaseId    = appname + ".base-id"
+	// images built from a Dockerfile embedded in dogi
+	labelDockerfileHash = appname + ".dockerfile-hash"
+	// helper containers (not dev containers) also have one of these
+	labelHelper  = appname + ".helper"
+	labelService = appname + ".service"
+	// apt-cacher launched in offline mode
+	labelCacherOffline = appname + ".offline"
+)
+
+// runLabels returns the labels of a container launched by dogi run
+func runLabels(imageName string, userMode bool) map[string]string {
+	userObj := userSingleton()
+	labels := map[string]string{
+		labelVersion:  Version,
+		labelUser:     userObj.Username,
+		labelUid:      userObj.Uid,
+		labelWorkdir:  opts.workDir,
+		labelImage:    imageName,
+		labelUserMode: strconv.FormatBool(userMode),
+		labelLaunched: time.Now().Format(time.RFC3339),
+	}
+	if opts.configPath != "" {
+		labels[labelConfig] = opts.configPath
+	}
+	return labels
+}
+
+// dockerfileHash identifies an embedded Dockerfile, to only rebuild
+// its image when dogi ships a different one
+func dockerfileHash(dockerfile string) string {
+	sum := sha256.Sum256([]byte(dockerfile))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func labelArgs(labels map[string]string) []string {
+	args := []string{}
+	for key, val := range labels {
+		args = append(args, fmt.Sprintf("--label=%s=%s", key, val))
+	}
+	sort.Strings(args)
+	return args
+}
+
+// isDevContainer is true for containers launched by dogi run
+func isDevContainer(labels map[string]string) bool {
+	_, ok := labels[labelVersion]
+	return ok && labels[labelHelper] == "" && labels[labelService] == ""
+}
+
+// dogiContainers returns the dev containers launched by dogi,
+// only running ones unless all is set
+func dogiContainers(all bool) []containerSummary {
+	containers, err := devContainers(all)
+	check(err)
+	return containers
+}
+
+// devContainers is dogiContainers returning the error of the runtime,
+// e.g. when the daemon isn't running
+func devContainers(all bool) ([]containerSummary, error) {
+	containers, err := backend().Containers(all)
+	if err != nil {
+		return nil, err
+	}
+	devContainers := []containerSummary{}
+	for _, cont := range containers {
+		if isDevContainer(cont.Labels) {
+			devContainers = append(devContainers, cont)
+		}
+	}
+	return devContainers, nil
+}
+
+// containerUser is the user of a dev container shell
+func containerUser(labels map[string]string) string {
+	if labels[labelUserMode] != "true" {
+		return "root"
+	}
+	return labels[labelUser]
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// a user image or the apt-cacher image can take a while to build
+	lockTimeout      = 10 * time.Minute
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// stateDir is where dogi keeps its host state, e.g. ~/.local/state/dogi
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, appname), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", appname), nil
+}
+
+// lockPath returns the lock file of a resource. Shared ones are for
+// the resources of the whole daemon (e.g. the apt-cacher) and are taken
+// by every user, they go straight into /run/lock or the temp dir. The
+// others are in the state dir of the user.
+func lockPath(name string, shared bool) (string, error) {
+	name = strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if shared {
+		var err error
+		for _, dir := range []string{"/run/lock", os.TempDir()} {
+			if err = checkSharedLockDir(dir); err == nil {
+				return filepath.Join(dir, appname+"-"+name+".lock"), nil
+			}
+		}
+		return "", err
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "locks")
+	return filepath.Join(dir, name+".lock"), os.MkdirAll(dir, 0755)
+}
+
+// checkSharedLockDir rejects a dir where another user could replace
+// the lock files: it must be owned by root or the user, and sticky if
+// others can write in it (like /tmp)
+func checkSharedLockDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	switch {
+	case !info.IsDir():
+		return fmt.Errorf("%s is not a directory", dir)
+	case !ok || (stat.Uid != 0 && int(stat.Uid) != os.Getuid()):
+		return fmt.Errorf("%s is owned by another user, not used for locks", dir)
+	case info.Mode().Perm()&0022 != 0 && info.Mode()&os.ModeSticky == 0:
+		return fmt.Errorf("%s is writable by others without sticky bit, not used for locks", dir)
+	}
+	return nil
+}
+
+// openLockFile opens a lock file read-only, flock doesn't need to
+// write. It's never followed if it's a symlink, nor written: another
+// user could have created it.
+func openLockFile(path string) (*os.File, error) {
+	// nonblocking in case it's a fifo
+	const flags = os.O_RDONLY | syscall.O_NOFOLLOW | syscall.O_CLOEXEC | syscall.O_NONBLOCK
+	file, err := os.OpenFile(path, flags|os.O_CREATE, 0644)
+	if os.IsPermission(err) {
+		// protected_regular forbids O_CREAT on the files of others
+		file, err = os.OpenFile(path, flags, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("lock %s: not a regular file", path)
+	}
+	return file, nil
+}
+
+// lockResource takes a host wide lock on a resource used by concurrent
+// dogi processes (the apt-cacher, a user image...), waiting for the one
+// holding it. The returned function releases it. Nothing is locked
+// with --dry-run, it doesn't change anything.
+func lockResource(ctx context.Context, name string, shared bool) (func(), error) {
+	if opts.dryRun {
+		return func() {}, nil
+	}
+	path, err := lockPath(name, shared)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	waiting := false
+	for {
+		err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != unix.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if !waiting {
+			logger.Printf("waiting for another %s to set up %s...\n", appname, name)
+			waiting = true
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("timed out after %s waiting for another %s to set up %s (lock: %s)",
+				lockTimeout, appname, name, path)
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	if waiting {
+		logger.Printf("%s ready\n", name)
+	}
+	return func() {
+		_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// mustLock is lockResource for the commands, exiting on timeout
+func mustLock(name string, shared bool) func() {
+	unlock, err := lockResource(context.Background(), name, shared)
+	if err != nil {
+		logger.Fatalf("Error: %s", err)
+	}
+	return unlock
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockResource(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	unlock, err := lockResource(context.Background(), "dogi/ubuntu:123", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// held: a second one waits until canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+	defer cancel()
+	if _, err := lockResource(ctx, "dogi/ubuntu:123", false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lock taken twice: %v", err)
+	}
+	// another resource isn't blocked
+	other, err := lockResource(context.Background(), "dogi/fedora:456", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	unlock()
+	again, err := lockResource(context.Background(), "dogi/ubuntu:123", false)
+	if err != nil {
+		t.Fatalf("lock not released: %s", err)
+	}
+	again()
+}
+
+func TestLockResourceWaits(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	unlock, err := lockResource(context.Background(), "image", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(2 * lockPollInterval)
+		unlock()
+	}()
+	start := time.Now()
+	second, err := lockResource(context.Background(), "image", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second()
+	if time.Since(start) < lockPollInterval {
+		t.Errorf("lock taken while held")
+	}
+}
+
+func TestOpenLockFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "victim")
+	if err := os.WriteFile(target, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "dogi-apt-cacher.lock")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+	if file, err := openLockFile(path); err == nil {
+		file.Close()
+		t.Fatal("symlink followed")
+	}
+	content, err := os.ReadFile(target)
+	info, _ := os.Stat(target)
+	if err != nil || string(content) != "keep" || info.Mode().Perm() != 0600 {
+		t.Errorf("symlink target changed: %q %s", content, info.Mode())
+	}
+}
+
+func TestOpenLockFileNotWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dogi-apt-cacher.lock")
+	if err := os.WriteFile(path, []byte("created by another user"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := openLockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if content, _ := os.ReadFile(path); string(content) != "created by another user" {
+		t.Errorf("lock file written: %q", content)
+	}
+}
+
+func TestCheckSharedLockDir(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode
+		ok   bool
+	}{
+		{"private", 0755, true},
+		{"sticky", 0777 | os.ModeSticky, true},
+		{"writable by others", 0777, false},
+		{"writable by the group", 0775, false},
+	}
+	for _, test := range tests {
+		dir := filepath.Join(t.TempDir(), "locks")
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(dir, test.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkSharedLockDir(dir); (err == nil) != test.ok {
+			t.Errorf("%s: checkSharedLockDir() = %v, want ok %t", test.name, err, test.ok)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSharedLockDir(file); err == nil {
+		t.Errorf("checkSharedLockDir() accepted a file")
+	}
+}
+
+func TestLockPathShared(t *testing.T) {
+	path, err := lockPath(aptCacherName, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(path, "/"+appname+"-"+aptCacherName+".lock") {
+		t.Errorf("lockPath() = %s", path)
+	}
+}
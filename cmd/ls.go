@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const lsExamples = `
+  - List the containers launched by {{.appname}} (running and stopped),
+    its volumes, networks and helper services
+
+    {{.appname}} ls
+
+  - Same, as json for scripting
+
+    {{.appname}} ls --json
+`
+
+var lsJson bool
+
+type lsContainer struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	User     string `json:"user"`
+	Workdir  string `json:"workdir"`
+	State    string `json:"state"`
+	Launched string `json:"launched"`
+	Config   string `json:"config,omitempty"`
+	// only for running containers
+	UptimeSeconds int64 `json:"uptimeSeconds,omitempty"`
+	ExecSessions  int   `json:"execSessions"`
+}
+
+type lsVolume struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+type lsNetwork struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type lsService struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Image         string `json:"image"`
+	State         string `json:"state"`
+	UptimeSeconds int64  `json:"uptimeSeconds,omitempty"`
+}
+
+type lsReport struct {
+	Containers []lsContainer `json:"containers"`
+	Volumes    []lsVolume    `json:"volumes"`
+	Networks   []lsNetwork   `json:"networks"`
+	Services   []lsService   `json:"services"`
+}
+
+func containerName(cont containerSummary) string {
+	if len(cont.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(cont.Names[0], "/")
+}
+
+// uptime returns how long a container has been running, 0 if it isn't
+func uptime(info containerInfo) time.Duration {
+	if !info.State.Running {
+		return 0
+	}
+	started, err := time.Parse(time.RFC3339Nano, info.State.StartedAt)
+	if err != nil {
+		return 0
+	}
+	return time.Since(started)
+}
+
+func lsDogi() lsReport {
+	report := lsReport{
+		Containers: []lsContainer{},
+		Volumes:    []lsVolume{},
+		Networks:   []lsNetwork{},
+		Services:   []lsService{},
+	}
+
+	containers, err := backend().Containers(true)
+	check(err)
+	for _, cont := range containers {
+		name := containerName(cont)
+		isService := cont.Labels[labelService] != "" || name == aptCacherCont
+		if !isDevContainer(cont.Labels) && !isService {
+			continue
+		}
+		info, err := backend().InspectContainer(cont.ID)
+		if err != nil {
+			// removed in the meantime
+			continue
+		}
+
+		if isService {
+			report.Services = append(report.Services, lsService{
+				ID:            shortId(cont.ID),
+				Name:          name,
+				Image:         cont.Image,
+				State:         cont.State,
+				UptimeSeconds: int64(uptime(info).Seconds()),
+			})
+			continue
+		}
+		report.Containers = append(report.Containers, lsContainer{
+			ID:            shortId(cont.ID),
+			Name:          name,
+			Image:         cont.Labels[labelImage],
+			User:          containerUser(cont.Labels),
+			Workdir:       cont.Labels[labelWorkdir],
+			State:         cont.State,
+			Launched:      cont.Labels[labelLaunched],
+			Config:        cont.Labels[labelConfig],
+			UptimeSeconds: int64(uptime(info).Seconds()),
+			ExecSessions:  len(info.ExecIDs),
+		})
+	}
+
+	volumes, err := backend().Volumes()
+	check(err)
+	for _, vol := range volumes {
+		switch vol.Name {
+		case cacheVolume, cargoCacheVolume, aptCacherVolume:
+			report.Volumes = append(report.Volumes, lsVolume{
+				Name:       vol.Name,
+				Mountpoint: vol.Mountpoint,
+				CreatedAt:  vol.CreatedAt,
+			})
+		}
+	}
+
+	// created with the apt-cacher
+	if network, err := backend().InspectNetwork(dogiNetwork); err == nil {
+		report.Networks = append(report.Networks, lsNetwork{
+			ID:   shortId(network.ID),
+			Name: network.Name,
+		})
+	}
+	return report
+}
+
+func stateLine(state string, uptimeSeconds int64) string {
+	if uptimeSeconds > 0 {
+		return "up " + humanDuration(time.Duration(uptimeSeconds)*time.Second)
+	}
+	return state
+}
+
+func printLs(report lsReport) {
+	fmt.Println(Blue("CONTAINERS"))
+	if len(report.Containers) == 0 {
+		fmt.Printf("no %s containers\n", appname)
+	} else {
+		rows := [][]string{{"CONTAINER ID", "NAME", "IMAGE", "USER", "WORKDIR", "STATUS", "EXECS"}}
+		for _, cont := range report.Containers {
+			rows = append(rows, []string{cont.ID, cont.Name, cont.Image, cont.User,
+				cont.Workdir, stateLine(cont.State, cont.UptimeSeconds),
+				strconv.Itoa(cont.ExecSessions)})
+		}
+		fmt.Println(strings.Join(tableLines(rows), "\n"))
+	}
+
+	fmt.Println()
+	fmt.Println(Blue("VOLUMES"))
+	if len(report.Volumes) == 0 {
+		fmt.Printf("no %s volumes\n", appname)
+	} else {
+		rows := [][]string{{"NAME", "MOUNTPOINT"}}
+		for _, vol := range report.Volumes {
+			rows = append(rows, []string{vol.Name, vol.Mountpoint})
+		}
+		fmt.Println(strings.Join(tableLines(rows), "\n"))
+	}
+
+	fmt.Println()
+	fmt.Println(Blue("NETWORKS"))
+	if len(report.Networks) == 0 {
+		fmt.Printf("no %s networks\n", appname)
+	} else {
+		rows := [][]string{{"NETWORK ID", "NAME"}}
+		for _, network := range report.Networks {
+			rows = append(rows, []string{network.ID, network.Name})
+		}
+		fmt.Println(strings.Join(tableLines(rows), "\n"))
+	}
+
+	fmt.Println()
+	fmt.Println(Blue("SERVICES"))
+	if len(report.Services) == 0 {
+		fmt.Printf("no %s services\n", appname)
+	} else {
+		rows := [][]string{{"CONTAINER ID", "NAME", "IMAGE", "STATUS"}}
+		for _, srv := range report.Services {
+			rows = append(rows, []string{srv.ID, srv.Name, srv.Image,
+				stateLine(srv.State, srv.UptimeSeconds)})
+		}
+		fmt.Println(strings.Join(tableLines(rows), "\n"))
+	}
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the containers, volumes, networks and services managed by dogi",
+	Long: helpTemplate(`
+List the containers launched by {{.appname}} run (including the stopped ones kept with --no-rm),
+with their image, user, working directory, uptime and attached exec sessions,
+and the volumes, networks and helper services shared by them.
+---------------------------------------------
+
+Examples:
+
+{{.lsExamples}}
+---------------------------------------------
+`, map[string]string{"lsExamples": lsExamples}),
+	Args: cobra.NoArgs,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// keep stdout for the json
+		if lsJson {
+			logger.SetOutput(os.Stderr)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		report := lsDogi()
+		if lsJson {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			check(enc.Encode(report))
+			return
+		}
+		printLs(report)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+	lsCmd.Flags().BoolVar(&lsJson, "json", false, "print the list as json")
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startupPhase is an independent step of dogi run
+type startupPhase struct {
+	name string
+	run  func(ctx context.Context) error
+	// if set, the phase starts once it's closed (it needs the result
+	// of another one), the wait isn't part of its timing
+	after <-chan struct{}
+}
+
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+var (
+	startTime     = time.Now()
+	phaseTimings  []phaseTiming
+	phaseTimingMu sync.Mutex
+)
+
+// timed runs fn recording how long it took for --timings
+func timed(name string, fn func() error) error {
+	start := time.Now()
+	defer func() {
+		phaseTimingMu.Lock()
+		defer phaseTimingMu.Unlock()
+		phaseTimings = append(phaseTimings, phaseTiming{name: name, duration: time.Since(start)})
+	}()
+	return fn()
+}
+
+// runPhases runs the phases concurrently and returns all their errors,
+// the first error cancels the context of the others. With --dry-run
+// they run one after the other to keep the order of the launch steps
+// (a phase comes after the ones it waits for).
+func runPhases(phases ...startupPhase) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make([]error, len(phases))
+	run := func(k int) {
+		defer func() {
+			// check() panics
+			if r := recover(); r != nil {
+				errs[k] = fmt.Errorf("%v", r)
+			}
+			if errs[k] != nil {
+				errs[k] = fmt.Errorf("%s: %w", phases[k].name, errs[k])
+				cancel()
+			}
+		}()
+		if phases[k].after != nil {
+			select {
+			case <-phases[k].after:
+			case <-ctx.Done():
+				errs[k] = ctx.Err()
+				return
+			}
+		}
+		errs[k] = timed(phases[k].name, func() error {
+			return phases[k].run(ctx)
+		})
+	}
+
+	if opts.dryRun {
+		for k := range phases {
+			if run(k); errs[k] != nil {
+				return errs[k]
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for k := range phases {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			run(k)
+		}(k)
+	}
+	wg.Wait()
+
+	// the phases canceled by an error aren't errors themselves
+	failed := []error{}
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			failed = append(failed, err)
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// printTimings shows how long each phase took (--timings)
+func printTimings() {
+	if !opts.timings {
+		return
+	}
+	phaseTimingMu.Lock()
+	defer phaseTimingMu.Unlock()
+	rows := [][]string{{"PHASE", "TIME"}}
+	for _, timing := range phaseTimings {
+		rows = append(rows, []string{timing.name, timing.duration.Round(time.Millisecond).String()})
+	}
+	rows = append(rows, []string{"total", time.Since(startTime).Round(time.Millisecond).String()})
+	logger.Println("timings:")
+	for _, line := range tableLines(rows) {
+		logger.Println("  " + strings.TrimRight(line, " "))
+	}
+}
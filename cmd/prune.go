@@ -2,8 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os/exec"
-	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -12,11 +10,27 @@ const pruneExamples = `
   - Delete unused and/or dangling containers, images and volumes
 
     {{.appname}} prune
+
+  - Only delete stopped containers launched by {{.appname}} run (--no-rm),
+    the package cache container is kept
+
+    {{.appname}} prune --only-dogi
 `
 
-func lastLine(out []byte) string {
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	return lines[len(lines)-1]
+var pruneOnlyDogi bool
+
+// pruneDogiContainers removes the stopped dev containers launched by dogi
+func pruneDogiContainers() {
+	deleted := 0
+	for _, cont := range dogiContainers(true) {
+		if cont.State == "running" {
+			continue
+		}
+		logger.Printf("remove %s (%s)\n", shortId(cont.ID), cont.Labels[labelImage])
+		check(backend().Remove(cont.ID))
+		deleted++
+	}
+	fmt.Printf("deleted %d containers\n", deleted)
 }
 
 var pruneCmd = &cobra.Command{
@@ -32,26 +46,21 @@ Examples:
 ---------------------------------------------
 `, map[string]string{"pruneExamples": pruneExamples}),
 	Run: func(cmd *cobra.Command, args []string) {
-		logger.Println("prune containers...")
-		out, err := exec.Command("docker",
-			"container", "prune", "-f").Output()
-		fmt.Println(lastLine(out))
-		check(err)
-
-		logger.Println("prune images...")
-		out, err = exec.Command("docker",
-			"image", "prune", "-f").Output()
-		fmt.Println(lastLine(out))
-		check(err)
-
-		logger.Println("prune volumes...")
-		out, err = exec.Command("docker",
-			"volume", "prune", "-f").Output()
-		fmt.Println(lastLine(out))
+		if pruneOnlyDogi {
+			pruneDogiContainers()
+			return
+		}
+		report, err := backend().Prune()
 		check(err)
+		fmt.Printf("deleted %d containers, %d images and %d volumes\n",
+			report.Containers, report.Images, report.Volumes)
+		if report.SpaceReclaimed > 0 {
+			fmt.Printf("Total reclaimed space: %s\n", humanSize(int64(report.SpaceReclaimed)))
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().BoolVar(&pruneOnlyDogi, "only-dogi", false, "only delete stopped containers launched by dogi")
 }
@@ -14,6 +14,7 @@ import (
 	"text/template"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 const (
@@ -21,10 +22,53 @@ const (
 	githubUrl        = "github.com/ntorresalberto/dogi"
 	dockerCmd        = "docker"
 	cidFileContainer = "/" + appname + ".cid"
+	// host sockets are mounted here, independently of the container user
+	socketsDir = "/run/" + appname
+
+	// volumes and services shared by dogi containers
+	cacheVolume      = appname + "_cache_vol"
+	cargoCacheVolume = appname + "_cargo-cache_vol"
+	aptCacherName    = "apt-cacher"
+	aptCacherVolume  = appname + "_" + aptCacherName + "_vol"
+	aptCacherCont    = appname + "_" + aptCacherName + "_cont"
+	aptCacherPort    = "3142/tcp"
+	// fixed, the proxy files of stopped containers (--no-rm) keep it
+	aptCacherHostPort = "31420"
+	// network of the services, joined by containers without --network=host
+	dogiNetwork = appname
 )
 
 func announceEnteringContainer() {
-	fmt.Println("going " + Green("inside") + " container, happy 🐳!")
+	fmt.Fprintln(logger.Writer(), "going "+Green("inside")+" container, happy 🐳!")
+}
+
+func isTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// setupTerminal drops --tty when dogi isn't run from a terminal (piped,
+// from a Makefile or in CI), then stdout and stderr of the container
+// command stay separate and dogi logs go to stderr
+func setupTerminal() {
+	if isTerminal() {
+		return
+	}
+	logger.SetOutput(os.Stderr)
+	logger.Println("not a terminal, launching without --tty")
+	args := []string{}
+	for _, arg := range dockerRunArgs {
+		if arg != "--tty" {
+			args = append(args, arg)
+		}
+	}
+	dockerRunArgs = args
+}
+
+// checkTerminal exits if there's no terminal to interactively ask for what
+func checkTerminal(what string) {
+	if !isTerminal() {
+		logger.Fatalf("Error: not a terminal, can't select %s, please provide it as argument", what)
+	}
 }
 
 func sessionPids() []int {
@@ -108,24 +152,9 @@ func only1Arg(cmd *cobra.Command, args []string, dockerType string) {
 }
 
 var (
-	Version          = "dev"
-	nvidiaRuntimePtr bool
-	gpusAllPtr       bool
-	privilegedPtr    bool
-	noUserPtr        bool
-	recentCtrPtr     bool
-	noRMPtr          bool
-	noUSBPtr         bool
-	noNethostPtr     bool
-	noCacherPtr      bool
-	noPIDIPCHostPtr  bool
-	workDirPtr       string
-	contNamePtr      string
-	devAccPtr        string
-	devRMWPtr        string
-	tempDirPtr       string
-	logger           = log.New(os.Stdout, appname+": ", log.Lmsgprefix)
-	dockerRunArgs    = []string{
+	Version       = "dev"
+	logger        = log.New(os.Stdout, appname+": ", log.Lmsgprefix)
+	dockerRunArgs = []string{
 		"--interactive",
 		"--tty",
 	}
@@ -150,13 +179,23 @@ Examples:
 ----------------
 {{.execExamples}}
 ----------------
+{{.buildExamples}}
+----------------
+{{.lsExamples}}
+----------------
+{{.cacherExamples}}
+----------------
 {{.pruneExamples}}
+{{.gcExamples}}
 ---------------------------------------------
 
 `, map[string]string{"runExamples": runExamples,
-			"execExamples": execExamples, "pruneExamples": pruneExamples}),
+			"execExamples": execExamples, "lsExamples": lsExamples,
+			"buildExamples": buildExamples, "cacherExamples": cacherExamples,
+			"pruneExamples": pruneExamples, "gcExamples": gcExamples}),
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			if cmd.CalledAs() != appname && insideContainer() {
+			// __init is the only command that runs inside the container
+			if cmd.CalledAs() != appname && cmd.Name() != initCmdName && insideContainer() {
 				fmt.Printf("Error: %s cannot run inside a container\n", appname)
 				syscall.Exit(1)
 			}
@@ -179,6 +218,18 @@ Examples:
 					}
 				} else {
 					fmt.Println("You are " + Yellow("OUTSIDE") + " a container (host machine)")
+					if conts, err := devContainers(false); err != nil {
+						logger.Printf("can't list the running containers: %s\n", err)
+					} else if len(conts) > 0 {
+						fmt.Printf("running %s containers:\n", appname)
+						for _, cont := range conts {
+							fmt.Printf("  %s %s as %s in %s\n", Green(shortId(cont.ID)),
+								cont.Labels[labelImage], containerUser(cont.Labels),
+								cont.Labels[labelWorkdir])
+						}
+						fmt.Println("open a new tty instance with: " +
+							Blue(fmt.Sprintf("%s exec", appname)))
+					}
 				}
 				fmt.Println("to see examples and docs: " + Blue(fmt.Sprintf("%s help", appname)))
 			}
@@ -188,6 +239,11 @@ Examples:
 	}
 )
 
+func init() {
+	rootCmd.PersistentFlags().StringVar(&opts.runtimeCli, "runtime-cli", "",
+		"container runtime: docker or podman (default: docker, or podman if docker is not installed)")
+}
+
 func panicKey(key string, mapWithoutKey map[string]string) {
 	if _, ok := mapWithoutKey[key]; ok {
 		panic(fmt.Errorf("%s should not exist in this dictionary", key))
@@ -220,7 +276,7 @@ func helpTemplate(templ string, parameters map[string]string) string {
 
 // find docker path for the exec command
 func dockerBinPath() (dockerBinPath string) {
-	dockerBinPath, err := exec.LookPath(dockerCmd)
+	dockerBinPath, err := exec.LookPath(backend().Name())
 	check(err)
 	return
 }
@@ -232,19 +288,20 @@ func merge(ss ...[]string) (s []string) {
 	return
 }
 
+// escapeSpaces quotes the values with spaces of --flag=value args,
+// the value is everything after the first '=' (e.g. --env=KEY=a b)
 func escapeSpaces(ss []string) (s []string) {
 	ss2 := make([]string, len(ss))
 	_ = copy(ss2, ss)
 	for ks := range ss2 {
-		spacespl := strings.Split(ss2[ks], " ")
-		if len(spacespl) > 1 {
-			eqspl := strings.Split(ss2[ks], "=")
-			if len(eqspl) != 2 {
-				panic(fmt.Sprintf("escapeSpaces: string should contain no more than a single '=':\n%v'", ss2[ks]))
-			}
-			ss2[ks] = fmt.Sprintf("%s='%s'", eqspl[0], eqspl[1])
+		if !strings.Contains(ss2[ks], " ") {
+			continue
+		}
+		if flag, value, ok := strings.Cut(ss2[ks], "="); ok {
+			ss2[ks] = flag + "=" + shellQuote(value)
+		} else {
+			ss2[ks] = shellQuote(ss2[ks])
 		}
-
 	}
 	return ss2
 }
@@ -263,6 +320,7 @@ func check(e error) {
 }
 
 func Execute() {
+	forwardDockerFlags()
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -270,12 +328,12 @@ func Execute() {
 }
 
 func workDirProvided() bool {
-	if workDirPtr == "" {
+	if opts.workDir == "" {
 		// means flag was not provided
 		var err error
-		workDirPtr, err = os.Getwd()
+		opts.workDir, err = os.Getwd()
 		check(err)
-		logger.Printf("current dir: %s\n", workDirPtr)
+		logger.Printf("current dir: %s\n", opts.workDir)
 		return false
 	}
 	return true
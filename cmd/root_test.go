@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscapeSpaces(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"run", "--rm", "ubuntu"}, []string{"run", "--rm", "ubuntu"}},
+		// the value is everything after the first '='
+		{[]string{"--env=KEY=a b"}, []string{"--env='KEY=a b'"}},
+		{[]string{"--workdir=/home/me/my dir"}, []string{"--workdir='/home/me/my dir'"}},
+		// the value of a separate arg, e.g. the git config of --env
+		{[]string{"--env", "GIT_CONFIG_VALUE_0=!f() { git status; }; f"},
+			[]string{"--env", "GIT_CONFIG_VALUE_0='!f() { git status; }; f'"}},
+		{[]string{"bash", "-c", "echo hello world"}, []string{"bash", "-c", "'echo hello world'"}},
+		{[]string{"--label=note=it's here"}, []string{`--label='note=it'\''s here'`}},
+		{[]string{}, []string{}},
+	}
+	for _, test := range tests {
+		if got := escapeSpaces(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("escapeSpaces(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
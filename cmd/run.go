@@ -1,73 +1,80 @@
 package cmd
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"math/rand"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
-	"text/template"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/ntorresalberto/dogi/assets"
 	"github.com/spf13/cobra"
 )
 
-var copyToContainerFiles = map[string]string{}
+var (
+	copyToContainerFiles   = map[string]string{}
+	copyToContainerFilesMu sync.Mutex
+)
 
 var userSingletonInstance *userSingletonType
 
 type userSingletonType struct {
 	*user.User
+	groups []string // containerGroups, looked up once
 }
 
 func userSingleton() *userSingletonType {
 	if userSingletonInstance == nil {
 		userObj, err := user.Current()
 		check(err)
-		userSingletonInstance = &userSingletonType{userObj}
+		userSingletonInstance = &userSingletonType{User: userObj}
 
 	}
 
 	return userSingletonInstance
 }
 
-type createGroupsCommand struct {
-	toAddGnames []string
-	cmd         string
-	gnames      string
-}
-
-func (m *userSingletonType) createGroupsCmd() createGroupsCommand {
+// containerGroups returns the host groups of the user (as name:gid)
+// that the user also gets inside the container
+func (m *userSingletonType) containerGroups() ([]string, error) {
 	if m == nil {
 		m = userSingleton()
 	}
+	if m.groups != nil {
+		return m.groups, nil
+	}
 
-	groupsCmd := createGroupsCommand{}
-	groupsCmd.cmd = createGroupCommandStr(m.Gid, m.Username)
 	// TODO: apparently you can use --group-add video from docker run?
 	// http://wiki.ros.org/docker/Tutorials/Hardware%20Acceleration#ATI.2FAMD
 	toAddGroups := map[string]string{"video": "", "realtime": ""}
+	// sockets are enough for audio, but devices like /dev/snd need the group
+	if opts.audio {
+		toAddGroups["audio"] = ""
+	}
+	groups := []string{}
 	groupIds, err := m.GroupIds()
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 
 	// logger.Println("  groups:")
 	for k := range groupIds {
 		gid := groupIds[k]
 		group, err := user.LookupGroupId(gid)
 		if err != nil {
-			logger.Printf("    - gid %s not found\n", gid)
-			panic(err)
+			return nil, fmt.Errorf("gid %s not found: %w", gid, err)
 		}
 		// logger.Printf("    - %s (%s)\n", group.Name, group.Gid)
 		if _, ok := toAddGroups[group.Name]; ok {
 			toAddGroups[group.Name] = group.Gid
-			groupsCmd.toAddGnames = append(groupsCmd.toAddGnames, group.Name)
-			groupsCmd.cmd += createGroupCommandStr(group.Gid, group.Name)
+			groups = append(groups, group.Name+":"+group.Gid)
 		}
 	}
 	for key, val := range toAddGroups {
@@ -76,9 +83,8 @@ func (m *userSingletonType) createGroupsCmd() createGroupsCommand {
 		}
 	}
 
-	groupsCmd.gnames = strings.Join(groupsCmd.toAddGnames, ",")
-
-	return groupsCmd
+	m.groups = groups
+	return groups, nil
 }
 
 func isSameDir(dir1, dir2 string) bool {
@@ -101,6 +107,8 @@ func isSameDir(dir1, dir2 string) bool {
 }
 
 func addCopyToContainerFile(srcpath, dstpath string) {
+	copyToContainerFilesMu.Lock()
+	defer copyToContainerFilesMu.Unlock()
 	if _, ok := copyToContainerFiles[srcpath]; !ok {
 		copyToContainerFiles[srcpath] = dstpath
 	} else {
@@ -111,20 +119,19 @@ func addCopyToContainerFile(srcpath, dstpath string) {
 
 func copyToContainer(srcpath, dstpath, dstcont string) {
 	logger.Printf("cp %s -> %s:%s\n", srcpath, dstcont[:8], dstpath)
-	dst := fmt.Sprintf("%s:%s", dstcont, dstpath)
-	// fmt.Printf("docker cp -aL %s %s\n", srcpath, dst)
-	out, err := exec.Command("docker", "cp", "-aL", srcpath, dst).CombinedOutput()
-	if err != nil {
-		fmt.Println(string(out))
-	}
-	check(err)
+	check(backend().CopyTo(dstcont, srcpath, dstpath))
 }
 
-func timeZone() string {
-	out, err := exec.Command("timedatectl", "show").Output()
-	check(err)
-	return strings.Split(strings.Split(strings.TrimSpace(string(out[:])),
-		"\n")[0], "=")[1]
+func timeZone(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "timedatectl", "show").Output()
+	if err != nil {
+		return "", fmt.Errorf("timedatectl show: %w", err)
+	}
+	_, zone, ok := strings.Cut(strings.Split(strings.TrimSpace(string(out[:])), "\n")[0], "=")
+	if !ok {
+		return "", fmt.Errorf("unexpected timedatectl output: %s", out)
+	}
+	return zone, nil
 }
 
 type contState struct {
@@ -132,35 +139,32 @@ type contState struct {
 }
 
 func imagesStartingWith(toComplete string) []string {
-	out, err := exec.Command("docker", "images").Output()
+	imgs, err := backend().Images()
 	check(err)
 
-	imglines := strings.Split(
-		strings.TrimSpace(string(out[:])), "\n")
 	images := []string{}
-	for _, imgline := range imglines {
-		fields := strings.Fields(imgline)
-		imgtag := fields[0] + ":" + fields[1]
-		if strings.HasPrefix(imgtag, toComplete) {
-			images = append(images, imgtag)
+	for _, img := range imgs {
+		for _, imgtag := range img.RepoTags {
+			if strings.HasPrefix(imgtag, toComplete) {
+				images = append(images, imgtag)
+			}
 		}
 	}
 	return images
 }
 
 func selectImage() string {
+	checkTerminal("an image")
 
-	out, err := exec.Command("docker", "images").Output()
+	imgs, err := backend().Images()
 	check(err)
 
-	options := strings.Split(
-		strings.TrimSpace(string(out[:])), "\n")
-
-	if len(options) == 0 {
+	if len(imgs) == 0 {
 		fmt.Printf("Error: no images locally available?\n")
 		syscall.Exit(1)
 	}
 
+	options := imageLines(imgs)
 	result := ""
 	prompt := &survey.Select{
 		Message: "Select an image:\n  " + options[0] + "\n",
@@ -176,123 +180,154 @@ func selectImage() string {
 	return imageId
 }
 
-func contRunning(name string) contState {
-	constate := contState{exists: true}
-	out, err := exec.Command("docker", "container",
-		"inspect", "-f", "{{ .State.Running }}", name).Output()
-	if err != nil {
-		constate.exists = false
-	} else {
-		constate.running = strings.TrimSpace(string(out[:])) == "true"
-	}
-	return constate
-}
-
 func cargoImage(name string) string {
-	out, err := exec.Command("docker",
-		"inspect", "-f", "{{ .Config.Env }}", name).Output()
+	meta, err := imageMetadata(name)
 	check(err)
-	outstrs := strings.Split(strings.TrimFunc(strings.TrimSpace(string(out)),
-		func(a rune) bool { return a == '[' || a == ']' }), " ")
-	cargoHome := ""
-	for _, varStr := range outstrs {
-		varstrspl := strings.Split(varStr, "=")
-		envVar := varstrspl[0]
-		if envVar == "CARGO_HOME" {
-			cargoHome = varstrspl[1]
-			break
-		}
-	}
-	return cargoHome
+	return meta.env("CARGO_HOME")
 }
 
-var aptSupportedDistros = []string{"Ubuntu", "Debian"}
-
-func supportedDistros() []string {
-	return append(aptSupportedDistros, "Fedora")
+// cacherSupported is true for the distro families whose package
+// manager can use the apt-cacher as proxy (apt-cacher-ng caches rpms too)
+func cacherSupported(distro string) bool {
+	return distro == familyDebian || distro == familyFedora
 }
 
-func imageExists(imageName string) bool {
-	_, err := exec.Command("docker", "image", "inspect",
-		imageName).Output()
-	return err == nil
+// useCacher tells if the package cache is used for an image, it's
+// optional: when it can't be set up the launch goes on without it
+func useCacher(imageName, distro string) bool {
+	if rootlessPodman() {
+		logger.Println("apt-cacher not supported with rootless podman (--no-cacher=ON)")
+		return false
+	}
+	if !cacherSupported(distro) {
+		logger.Println("image is neither apt nor dnf based, disabling apt-cacher (--no-cacher=ON)")
+		return false
+	}
+	// e.g. minimal images without package manager
+	if _, _, err := imagePackageConf(imageName, distro); err != nil {
+		logger.Printf("%s, disabling apt-cacher (--no-cacher=ON)\n", err)
+		return false
+	}
+	if network, ok := cacherNetwork(); !ok {
+		logger.Printf("apt-cacher not reachable with --network=%s (--no-cacher=ON)\n", network)
+		return false
+	}
+	if opts.noCacher {
+		logger.Println("disabling apt-cacher (--no-cacher=ON)")
+		return false
+	}
+	logger.Println("using apt-cacher, disable it with --no-cacher")
+	return true
 }
 
-func imageDistro(imageName string) string {
-	out, err := exec.Command("docker", "run", "--rm", "--tty",
-		"--entrypoint=cat",
-		imageName, "/etc/os-release").Output()
-	if err != nil {
-		logger.Println("Error: failed to verify image distro:")
-		logger.Printf("image: %s\n", imageName)
-		logger.Printf("error: %s\n", err.Error())
-		logger.Printf("Please share this log the %s devs at:\n", appname)
-		logger.Fatalf("https://github.com/ntorresalberto/dogi/issues/new")
-	}
-	check(err)
+// aptCacherImage is built from the Dockerfile embedded in dogi
+var aptCacherImage = fmt.Sprintf("%s/%s", appname, aptCacherName)
 
-	for _, val := range supportedDistros() {
-		if strings.Contains(string(out), val) {
-			return val
-		}
+// cacherNetwork returns the network where the dev container reaches the
+// apt-cacher, empty with host networking (through its port published on
+// localhost). It isn't ok for networks without access to it.
+func cacherNetwork() (string, bool) {
+	if !opts.noNethost {
+		return "", true
+	}
+	networks := dockerArgValues(dockerExtraArgs, "network", "net")
+	if len(networks) == 0 {
+		return dogiNetwork, true
+	}
+	switch network := networks[0]; {
+	case network == "host":
+		return "", true
+	case network == "none", strings.HasPrefix(network, "container:"):
+		return network, false
+	default:
+		return network, true
 	}
-	return ""
 }
 
-func aptCacherSupported(distro string) bool {
-	for _, val := range aptSupportedDistros {
-		if val == distro {
-			return true
-		}
+// ensureDogiNetwork creates the network of the dogi services
+func ensureDogiNetwork() error {
+	if _, err := backend().InspectNetwork(dogiNetwork); err == nil {
+		return nil
+	}
+	labels := map[string]string{labelVersion: Version}
+	if opts.dryRun {
+		addLaunchStep("create network "+dogiNetwork,
+			shellQuote(merge([]string{backend().Name(), "network", "create"},
+				labelArgs(labels), []string{dogiNetwork})...))
+		return nil
 	}
-	return false
+	logger.Printf("create network %s\n", dogiNetwork)
+	return backend().CreateNetwork(dogiNetwork, labels)
 }
 
-func setAptCacher() string {
-	baseName := "apt-cacher"
-	imgName := fmt.Sprintf("%s/%s", appname, baseName)
+// startAptCacher makes sure the apt-cacher container is up to date
+// and running, callers hold the apt-cacher lock (lockResource)
+func startAptCacher(ctx context.Context) error {
+	imgName := aptCacherImage
+	volume := aptCacherVolume + ":/var/cache/apt-cacher-ng"
 
-	{
+	// only built when the embedded Dockerfile changes
+	imgLabels := map[string]string{
+		labelVersion:        Version,
+		labelDockerfileHash: dockerfileHash(assets.AptCacheDockerfile),
+	}
+	imgInfo, imgErr := backend().InspectImage(imgName)
+	imgBuilt := false
+	if imgErr == nil && imgInfo.Config.Labels[labelDockerfileHash] == imgLabels[labelDockerfileHash] {
+		logger.Printf("apt cacher image up to date: %s\n", imgName)
+	} else if opts.dryRun {
+		imgBuilt = true
+		// a context dir like Build, podman doesn't read a Dockerfile from stdin
+		addLaunchStep("build apt cacher image: "+imgName, strings.Join([]string{
+			"ctx=$(mktemp -d)",
+			heredoc(`cat > "${ctx}/Dockerfile"`, assets.AptCacheDockerfile),
+			shellQuote(merge([]string{backend().Name(), "build", "--tag=" + imgName},
+				labelArgs(imgLabels))...) + ` "${ctx}"`,
+			`rm -rf "${ctx}"`,
+		}, "\n"))
+	} else {
+		imgBuilt = true
 		logger.Printf("build apt cacher image: %s\n", imgName)
-		// build apt-cache-ng image
-		//dir, err := os.MkdirTemp("", "dogi_apt-cache")
-		dir, err := os.MkdirTemp(tempDirPtr, "dogi_apt-cache")
-		check(err)
-		tmpfn := filepath.Join(dir, "Dockerfile")
-		check(os.WriteFile(tmpfn, []byte(assets.AptCacheDockerfile), 0666))
-		logger.Printf("temp dir: %s\n", dir)
-		logger.Printf("temp Dockerfile: %s\n", tmpfn)
-
-		cmd := exec.Command("docker",
-			"build", "--progress=plain", "-t", imgName, ".")
-		cmd.Dir = dir
-		out, err := cmd.Output()
-		if err != nil {
-			fmt.Println(string(out))
-			panic(err)
+		if err := backend().Build(ctx, imgName, assets.AptCacheDockerfile, nil, imgLabels); err != nil && imgErr == nil {
+			// e.g. offline, with an image from dogi cacher import
+			logger.Printf("WARNING: failed to build %s, using the existing one: %s\n", imgName, err)
+			imgBuilt = false
+		} else if err != nil {
+			return fmt.Errorf("build %s: %w", imgName, err)
 		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-		check(os.RemoveAll(dir)) // clean up
+	if err := ensureDogiNetwork(); err != nil {
+		return err
 	}
 
 	// launch apt-cacher container
-	contName := fmt.Sprintf("%s_%s_cont", appname, baseName)
+	contName := aptCacherCont
+	contLabels := map[string]string{labelVersion: Version, labelService: aptCacherName,
+		labelCacherOffline: strconv.FormatBool(opts.cacherOffline)}
+	contEnv := []string{}
+	if opts.cacherOffline {
+		contEnv = append(contEnv, "ACNG_OFFLINE=1")
+	}
 
 	contNeedsRestart := false
-	constate := contRunning(contName)
+	contInfo, err := backend().InspectContainer(contName)
+	constate := contState{exists: err == nil, running: contInfo.State.Running}
 	if constate.exists {
 		// check container image is up to date
-		out, err := exec.Command("docker", "image",
-			"inspect", "-f", "{{ .Id }}", imgName).Output()
-		check(err)
-		imageId := strings.TrimSpace(string(out[:]))
-
-		out, err = exec.Command("docker", "container",
-			"inspect", "-f", "{{ .Image }}", contName).Output()
-		check(err)
-		contImageId := strings.TrimSpace(string(out[:]))
+		// (with --dry-run it isn't rebuilt yet)
+		if imgBuilt && opts.dryRun {
+			contNeedsRestart = true
+		} else if imgBuilt {
+			if imgInfo, err = backend().InspectImage(imgName); err != nil {
+				return err
+			}
+		}
 
-		if imageId != contImageId {
+		if !contNeedsRestart && imgInfo.ID != contInfo.Image {
 			logger.Printf("need to restart apt cache container")
 			contNeedsRestart = true
 		}
@@ -300,173 +335,289 @@ func setAptCacher() string {
 		if !constate.running {
 			contNeedsRestart = true
 		}
+
+		if contInfo.Config.Labels[labelCacherOffline] != contLabels[labelCacherOffline] {
+			logger.Printf("need to restart apt cache container (offline mode: %t)", opts.cacherOffline)
+			contNeedsRestart = true
+		}
+
+		// launched by older versions on the default network or a random port
+		if _, ok := contInfo.NetworkSettings.Networks[dogiNetwork]; !ok ||
+			contInfo.LocalPort(aptCacherPort) != aptCacherHostPort {
+			logger.Printf("need to relaunch apt cache container in the %s network, port %s",
+				dogiNetwork, aptCacherHostPort)
+			contNeedsRestart = true
+		}
 	}
 
 	if contNeedsRestart {
 		if constate.running {
 			logger.Printf("container running, stopping...")
-			_, err := exec.Command("docker", "container",
-				"stop", contName).Output()
-			check(err)
+			if opts.dryRun {
+				addLaunchStep("stop outdated apt-cacher container",
+					shellQuote(backend().Name(), "container", "stop", contName))
+			} else if err := backend().Stop(contName); err != nil {
+				return err
+			}
 		}
 
 		if constate.exists {
 			logger.Printf("container exists, removing...")
-			_, err := exec.Command("docker", "container",
-				"rm", contName).Output()
-			check(err)
+			if opts.dryRun {
+				addLaunchStep("remove outdated apt-cacher container",
+					shellQuote(backend().Name(), "container", "rm", contName))
+			} else if err := backend().Remove(contName); err != nil {
+				return err
+			}
 		}
+		constate.exists = false
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// find out apt-cacher ip
-	// is it possible to have multiple IPs for this container?
-	out, err := exec.Command("docker", "container",
-		"inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", contName).Output()
-	if err != nil {
+	if !constate.exists {
 		logger.Printf("container %s not found, launching...", contName)
-		_, err = exec.Command("docker",
-			"run", "-d", "--restart=always",
-			fmt.Sprintf("--volume=%s_%s_vol:/var/cache/apt-cacher-ng",
-				appname, baseName),
-			fmt.Sprintf("--name=%s", contName),
-			imgName,
-		).Output()
-		logger.Printf("apt-cacher container started")
-		check(err)
+		if opts.dryRun {
+			envArgs := []string{}
+			for _, env := range contEnv {
+				envArgs = append(envArgs, "--env="+env)
+			}
+			addLaunchStep("launch apt-cacher container",
+				shellQuote(merge([]string{backend().Name(), "run", "-d", "--restart=always",
+					"--volume=" + volume, "--name=" + contName, "--network=" + dogiNetwork,
+					"--publish=127.0.0.1:" + aptCacherHostPort + ":" + aptCacherPort},
+					labelArgs(contLabels), envArgs,
+					[]string{imgName})...))
+		} else {
+			_, err := backend().RunDetached(containerSpec{
+				Name:       contName,
+				Image:      imgName,
+				Volumes:    []string{volume},
+				Restart:    "always",
+				Labels:     contLabels,
+				Network:    dogiNetwork,
+				LocalPorts: map[string]string{aptCacherPort: aptCacherHostPort},
+				Env:        contEnv,
+			})
+			if err != nil {
+				return err
+			}
+			logger.Printf("apt-cacher container started")
+		}
+	}
+	return nil
+}
 
-		out, err = exec.Command("docker", "container",
-			"inspect", "-f", "{{ .NetworkSettings.IPAddress }}", contName).Output()
-		check(err)
+// cacherProxyAddr replaces the apt-cacher address with --dry-run,
+// it's only known once the container is running
+const cacherProxyAddr = "DOGI_CACHER_ADDR"
+
+// cacherProxyConfig returns the package manager config of a distro
+// family using proxyUrl, imageConf is the config of the image (dnf)
+func cacherProxyConfig(distro, imageConf, proxyUrl string) string {
+	if distro != familyFedora {
+		return fmt.Sprintf("Acquire::http { Proxy \"%s\"; };\n", proxyUrl)
+	}
+	// dnf.conf has no drop-in directory, set proxy in its [main]
+	lines := []string{}
+	inMain, found := false, false
+	confLines := []string{}
+	if imageConf = strings.TrimRight(imageConf, "\n"); imageConf != "" {
+		confLines = strings.Split(imageConf, "\n")
+	}
+	for _, line := range confLines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inMain = trimmed == "[main]"
+		}
+		if inMain && strings.HasPrefix(strings.ReplaceAll(trimmed, " ", ""), "proxy=") {
+			continue
+		}
+		lines = append(lines, line)
+		if trimmed == "[main]" && !found {
+			lines = append(lines, "proxy="+proxyUrl)
+			found = true
+		}
 	}
-	ip := strings.TrimSpace(string(out[:]))
-	if ip == "" {
-		panic(fmt.Errorf("%s found but not running?", contName))
+	if !found {
+		lines = append(lines, "[main]", "proxy="+proxyUrl)
 	}
-	logger.Printf("container %s found: %s", contName, ip)
+	return strings.Join(lines, "\n") + "\n"
+}
 
-	aptCacherConf := fmt.Sprintf("Acquire::http { Proxy \"http://%s:3142\"; };", ip)
+// imagePackageConf returns the package manager config file of an
+// image to replace, and its content (from the image metadata)
+func imagePackageConf(imageName, distro string) (string, string, error) {
+	if distro != familyFedora {
+		return "/etc/apt/apt.conf.d/01proxy", "", nil
+	}
+	meta, err := imageMetadata(imageName)
+	if err != nil {
+		return "", "", err
+	}
+	if meta.PackageConfPath == "" {
+		return "", "", fmt.Errorf("neither /etc/dnf/dnf.conf nor /etc/yum.conf found in %s", imageName)
+	}
+	return meta.PackageConfPath, meta.PackageConf, nil
+}
 
-	aptCacherFile, err := os.CreateTemp(tempDirPtr, fmt.Sprintf(".%s_%s_*", appname, baseName))
-	check(err)
-	logger.Printf("apt-cacher file: %s", aptCacherFile.Name())
-	check(os.WriteFile(aptCacherFile.Name(), []byte(aptCacherConf), 0666))
-	return aptCacherFile.Name()
+// cacherAddr returns the apt-cacher host:port for a dev container in
+// network (empty for host networking), empty if it isn't reachable.
+// It stays valid when the apt-cacher restarts (e.g. with the daemon):
+// the host port is fixed and user networks resolve the container name,
+// only the default bridge network has no dns and needs its address.
+func cacherAddr(contInfo containerInfo, network string) string {
+	port, _, _ := strings.Cut(aptCacherPort, "/")
+	switch {
+	case network == "":
+		if contInfo.LocalPort(aptCacherPort) == "" {
+			return ""
+		}
+		return "127.0.0.1:" + aptCacherHostPort
+	case defaultBridge(network):
+		if ip := contInfo.NetworkIP(network); ip != "" {
+			return ip + ":" + port
+		}
+		return ""
+	}
+	if _, ok := contInfo.NetworkSettings.Networks[network]; !ok {
+		return ""
+	}
+	return aptCacherCont + ":" + port
 }
 
-func createGroupCommandStr(gid, groupName string) string {
-	createGroupTempl := `
-outside_gid="{{.outside_gid}}"
-outside_gname="{{.outside_gname}}"
-
-echo "  - {{.outside_gname}} (gid {{.outside_gid}})"
-
-warnings=0
-group_exists=0
-
-echo "    . check gid {{.outside_gid}} is valid..."
-inside_gid_bygid=$(getent group "{{.outside_gid}}" | cut -f3 -d: || true)
-inside_gid_bygname=$(getent group "{{.outside_gname}}" | cut -f3 -d: || true)
-# echo "      inside_gid_bygid: ${inside_gid_bygid}"
-# echo "      inside_gid_bygname: ${inside_gid_bygname}"
-
-echo -n "     - gid (by gid): "
-herewarn=0
-if [ "${inside_gid_bygid}" ]; then
-  group_exists=1
-  if [ "${inside_gid_bygid}" != "{{.outside_gid}}" ]; then
-    echo "WARNING"
-    echo "      -> gid (by gid): exists inside container exists and differs from outside:"
-    echo "      -> inside_gid_bygid: ${inside_gid_bygid}, outside container: {{.outside_gid}}"
-    warnings=1
-    herewarn=1
-  fi
-fi
-if [ "${herewarn}" == "0" ]; then
-    echo "OK"
-fi
-
-echo -n "     - gid (by gname): "
-herewarn=0
-if [ "${inside_gid_bygname}" ]; then
-  group_exists=1
-  if [ "${inside_gid_bygname}" != "{{.outside_gid}}" ]; then
-    echo "WARNING"
-    echo "      -> gid (by gname) inside container exists and differs from outside:"
-    echo "      -> inside_gid_bygname: ${inside_gid_bygname}, outside container: {{.outside_gid}}"
-    warnings=1
-    herewarn=1
-  fi
-fi
-if [ "${herewarn}" == "0" ]; then
-    echo "OK"
-fi
-# ---------------------------------------------------------------------------
-
-echo "    . check group name {{.outside_gname}} is valid..."
-inside_gname_bygid=$(getent group "{{.outside_gid}}" | cut -f1 -d: || true)
-inside_gname_bygname=$(getent group "{{.outside_gname}}" | cut -f1 -d: || true)
-# echo "      inside_gname_bygid: ${inside_gname_bygid}"
-# echo "      inside_gname_bygname: ${inside_gname_bygname}"
-
-echo -n "     - groupname (by gid): "
-herewarn=0
-if [ "${inside_gname_bygid}" ]; then
-  group_exists=1
-  if [ "${inside_gname_bygid}" != "{{.outside_gname}}" ]; then
-    echo "WARNING"
-    echo "      -> groupname (by gid) exists inside container exists and differs from outside:"
-    echo "      -> inside_gname_bygid: ${inside_gname_bygid}, outside container: {{.outside_gname}}"
-    warnings=1
-    herewarn=1
-  fi
-fi
-if [ "${herewarn}" == "0" ]; then
-    echo "OK"
-fi
-
-echo -n "     - groupname (by gname): "
-herewarn=0
-if [ "${inside_gname_bygname}" ]; then
-  group_exists=1
-  if [ "${inside_gname_bygname}" != "{{.outside_gname}}" ]; then
-    echo "WARNING"
-    echo "      -> groupname (by gname) exists inside container exists and differs from outside:"
-    echo "      -> inside_gname_bygname: ${inside_gname_bygname}, outside container: {{.outside_gname}}"
-    warnings=1
-    herewarn=1
-  fi
-fi
-if [ "${herewarn}" == "0" ]; then
-    echo "OK"
-fi
-
-# echo "  group_exists: ${group_exists}"
-# echo "        warnings: ${warnings}"
-if [ "${warnings}" == "0" ]; then
-  if [ "${group_exists}" == "0" ]; then
-    echo "     => gid {{.outside_gid}} not found inside container, create"
-    groupadd -g "{{.outside_gid}}" "{{.outside_gname}}";
-  else
-    echo "     => gid {{.outside_gid}} ({{.outside_gname}}) exists inside container"
-  fi
-else
-  echo "    ---------------------------------"
-  echo "    Warning: there were some issues with group {{.outside_gname}} ({{.outside_gid}}),"
-  echo "    check log above but very often this does not pose a problem"
-  echo "    (if it does create an issue with the running log output)."
-  echo "    https://github.com/ntorresalberto/dogi/issues"
-  echo "    ---------------------------------"
-  # exit 1
-fi
-`
-	var out bytes.Buffer
-	err := template.Must(template.New("").Option("missingkey=error").Parse(createGroupTempl)).Execute(&out,
-		map[string]string{"outside_gid": gid,
-			"outside_gname": groupName,
-		})
-	check(err)
+// defaultBridge is true for the default network of docker and podman
+func defaultBridge(network string) bool {
+	return network == "bridge" || network == "podman"
+}
+
+// cacherAddrShell is cacherAddr for --dry-run scripts
+func cacherAddrShell(network string) string {
+	port, _, _ := strings.Cut(aptCacherPort, "/")
+	switch {
+	case network == "":
+		return "127.0.0.1:" + aptCacherHostPort
+	case !defaultBridge(network):
+		return aptCacherCont + ":" + port
+	}
+	return "$(" + shellQuote(backend().Name(), "container", "inspect", "-f",
+		fmt.Sprintf(`{{(index .NetworkSettings.Networks "%s").IPAddress}}`, network),
+		aptCacherCont) + "):" + port
+}
+
+// setCacher starts the apt-cacher and adds the package manager proxy
+// config of the image distro to the files copied to the container
+func setCacher(ctx context.Context, imageName, distro string) error {
+	// from the image metadata, before waiting for the lock
+	dstpath, imageConf, err := imagePackageConf(imageName, distro)
+	if err != nil {
+		return err
+	}
+	// another dogi run might be starting it too
+	unlock, err := lockResource(ctx, aptCacherName, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := startAptCacher(ctx); err != nil {
+		return err
+	}
+	contName := aptCacherCont
+	network, _ := cacherNetwork()
+
+	// the apt-cacher is always in the dogi network, it also joins the
+	// one given with --network (with --dry-run it might not exist yet)
+	contInfo, err := backend().InspectContainer(contName)
+	if err != nil && !opts.dryRun {
+		return err
+	}
+	_, connected := contInfo.NetworkSettings.Networks[network]
+	if network != "" && network != dogiNetwork && !connected {
+		if opts.dryRun {
+			addLaunchStep("connect apt-cacher to network "+network,
+				shellQuote(backend().Name(), "network", "connect", network, contName))
+		} else if err := backend().ConnectNetwork(network, contName); err != nil {
+			return fmt.Errorf("connect %s to %s: %w", contName, network, err)
+		} else if contInfo, err = backend().InspectContainer(contName); err != nil {
+			return err
+		}
+	}
 
-	return out.String()
+	cacherPattern := fmt.Sprintf(".%s_%s_*", appname, aptCacherName)
+	if opts.dryRun {
+		path := tempFilePath(cacherPattern)
+		addLaunchStep("create package cache proxy config: "+path, strings.Join([]string{
+			"addr=" + cacherAddrShell(network),
+			heredoc("cat > "+shellQuote(path),
+				cacherProxyConfig(distro, imageConf, "http://"+cacherProxyAddr)),
+			fmt.Sprintf(`sed -i "s/%s/${addr}/" %s`, cacherProxyAddr, shellQuote(path)),
+		}, "\n"))
+		addCopyToContainerFile(path, dstpath)
+		return nil
+	}
+
+	if !contInfo.State.Running {
+		return fmt.Errorf("%s found but not running?", contName)
+	}
+	addr := cacherAddr(contInfo, network)
+	if addr == "" {
+		return fmt.Errorf("%s has no address for network '%s'", contName, network)
+	}
+	logger.Printf("container %s found: %s", contName, addr)
+
+	cacherFile := writeTempFile(cacherPattern,
+		cacherProxyConfig(distro, imageConf, "http://"+addr))
+	logger.Printf("package cache proxy file: %s -> %s", cacherFile, dstpath)
+	addCopyToContainerFile(cacherFile, dstpath)
+	return nil
+}
+
+// runFlagConflicts returns the docker run flags already set by dogi
+func runFlagConflicts() map[string]string {
+	conflicts := map[string]string{
+		"interactive": "always set by " + appname,
+		"tty":         "always set by " + appname,
+		"detach":      appname + " attaches to the container",
+		"cidfile":     "used by " + appname + " to find the container id",
+		"userns":      appname + " sets the user namespace",
+		"user":        appname + " uses your user, or root with --no-user",
+		"workdir":     "use --workdir instead",
+	}
+	if opts.noRM {
+		conflicts["rm"] = "--no-rm was given too"
+	} else {
+		conflicts["rm"] = "set by default, use --no-rm to disable it"
+	}
+	if !opts.noNethost {
+		conflicts["network"] = appname + " uses --network=host, add --no-nethost"
+		conflicts["net"] = conflicts["network"]
+	}
+	if !opts.noPIDIPCHost {
+		conflicts["pid"] = appname + " uses --pid=host, add --no-pid-ipc-host"
+		conflicts["ipc"] = appname + " uses --ipc=host, add --no-pid-ipc-host"
+	}
+	if opts.gpusAll {
+		conflicts["gpus"] = "already set by --gpus-all"
+	}
+	if opts.nvidiaRuntime {
+		conflicts["runtime"] = "already set by --runtime-nvidia"
+	}
+	if !opts.noUser {
+		conflicts["entrypoint"] = appname + " needs it to create your user, add --no-user"
+	}
+	return conflicts
+}
+
+// rootlessPodman is true when the backend can't do everything root docker does
+func rootlessPodman() bool {
+	podman, ok := backend().(podmanBackend)
+	return ok && podman.rootless()
+}
+
+// podmanKeepId is true when podman creates the user inside the container
+func podmanKeepId() bool {
+	return rootlessPodman() && !opts.noUser
 }
 
 const runExamples = `
@@ -492,6 +643,52 @@ const runExamples = `
 
 	{{.appname}} run ubuntu --device-access "/dev/video0"
 
+  - Pass any docker run flag, it will be forwarded to docker
+
+    {{.appname}} run --shm-size=2g --publish 8080:80 ubuntu
+
+  - The ssh and gpg agents and the git config are forwarded by default
+    (git push and signed commits work inside), to disable it
+
+    {{.appname}} run --no-agents ubuntu
+
+  - Launch a container with sound (pulseaudio or pipewire)
+
+    {{.appname}} run --audio ubuntu
+
+  - Launch without any display setup, e.g. in CI or over ssh
+    (automatic when neither DISPLAY nor WAYLAND_DISPLAY is usable)
+
+    {{.appname}} run --headless ubuntu -- make test
+
+  - Launch faster with an image that has your user already set up
+    (built the first time, see {{.appname}} build)
+
+    {{.appname}} run --build ubuntu
+
+  - See how long each startup step took (image, apt-cacher, xauth...)
+
+    {{.appname}} run --timings ubuntu
+
+  - Keep {{.appname}} running while inside the container, to get the exit code
+    and clean up its temp files when it exits (by default docker replaces it)
+
+    {{.appname}} run --supervise ubuntu -- make test
+
+  - Review what would be executed, or get it as a bash script (the os-release
+    of an image not seen before is still read, with a container created and
+    removed right away)
+
+    {{.appname}} run --dry-run ubuntu
+    {{.appname}} run --print ubuntu > launch.sh
+
+  - Use podman instead of docker (default if docker is not installed)
+
+    {{.appname}} run --runtime-cli=podman ubuntu
+
+  - Launch the image and options defined in the closest .{{.appname}}.yaml
+
+    {{.appname}} run
 `
 
 var (
@@ -507,10 +704,12 @@ Examples:
 
 {{ .runExamples}}
 ---------------------------------------------
-`, map[string]string{"runExamples": runExamples}),
-		// FParseErrWhitelist: cobra.FParseErrWhitelist{
-		// 	UnknownFlags: true,
-		// },
+
+Project config:
+{{ .configExamples}}
+---------------------------------------------
+`, map[string]string{"runExamples": runExamples, "configExamples": configExamples}),
+		// NOTE: unknown flags are docker flags, see forwardDockerFlags
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			if len(args) != 0 {
 				return nil, cobra.ShellCompDirectiveNoFileComp
@@ -521,6 +720,14 @@ Examples:
 			// fmt.Println("args:", args)
 			// fmt.Println("cmd.Args:", cmd.Args)
 			only1Arg(cmd, args, "image")
+			loadConfig(cmd)
+			if opts.printScript {
+				// keep stdout for the script only
+				logger.SetOutput(os.Stderr)
+				opts.dryRun = true
+			} else {
+				setupTerminal()
+			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			// logger.Println("len(args):", len(args))
@@ -529,7 +736,10 @@ Examples:
 			var entrypoint []string
 			imageName := ""
 			beforeArgs := beforeDashArgs(cmd, args)
-			if len(beforeArgs) == 0 {
+			if len(beforeArgs) == 0 && opts.image != "" {
+				imageName = opts.image
+				logger.Printf("image from %s", opts.configPath)
+			} else if len(beforeArgs) == 0 {
 				imageName = selectImage()
 				logger.Printf("imageId: %s", imageName)
 
@@ -544,49 +754,94 @@ Examples:
 			check(err)
 
 			// if tempdir is not provided, use OS default
-			if tempDirPtr == "" {
-				tempDirPtr = os.TempDir()
+			if opts.tempDir == "" {
+				opts.tempDir = os.TempDir()
 			}
+			check(newSessionDir())
 
-			// create xauth magic cookie file
-			xauthfile, err := os.CreateTemp(tempDirPtr, fmt.Sprintf(".%s*.xauth", appname))
-
-			check(err)
-			logger.Println("temp xauth file:", xauthfile.Name())
-			addCopyToContainerFile(xauthfile.Name(), "/.xauth")
-
-			xauthCmdPath, err := exec.LookPath("xauth")
-			check(err)
-
-			const displayEnvVar string = "DISPLAY"
-			displayEnv, ok := os.LookupEnv(displayEnvVar)
-			if !ok {
-				displayEnv = ":0"
-				logger.Printf("WARNING: env %s not set, using %s=%s\n",
-					displayEnvVar, displayEnvVar, displayEnv)
-			} else {
-				logger.Printf("env %s=%s\n", displayEnvVar, displayEnv)
+			wayland, x11 := setupDisplay()
+			userObj := userSingleton()
+			userMode := !opts.noUser && userObj.Uid != "0"
+
+			// independent startup steps, run concurrently
+			var (
+				xauthArgs        []string
+				tz               string
+				distro           string // empty if not supported
+				cargoHomeContDir string
+				cacherUsed       bool
+			)
+			// the apt-cacher needs the distro of the image
+			imageDone := make(chan struct{})
+			phases := []startupPhase{}
+			if x11 {
+				phases = append(phases, startupPhase{name: "xauth", run: func(ctx context.Context) (err error) {
+					xauthArgs, err = x11Args(ctx, bashCmdPath)
+					return err
+				}})
+			}
+			phases = append(phases, startupPhase{name: "image", run: func(ctx context.Context) error {
+				defer close(imageDone)
+				if !imageExists(imageName) {
+					return fmt.Errorf("docker image or tag '%s' doesn't exist? try '%s pull %s' first",
+						imageName, backend().Name(), imageName)
+				}
+				distro = imageDistro(imageName)
+				cargoHomeContDir = cargoImage(imageName)
+				cacherUsed = useCacher(imageName, distro)
+				return nil
+			}}, startupPhase{name: "apt-cacher", after: imageDone, run: func(ctx context.Context) error {
+				if !cacherUsed {
+					return nil
+				}
+				return setCacher(ctx, imageName, distro)
+			}}, startupPhase{name: "timezone", run: func(ctx context.Context) (err error) {
+				tz, err = timeZone(ctx)
+				return err
+			}})
+			if !opts.dryRun {
+				phases = append(phases, startupPhase{name: "gc", run: func(ctx context.Context) error {
+					// sessions of previous launches, not needed to launch this one
+					if _, err := gcSessions(ctx, opts.tempDir); err != nil {
+						logger.Printf("WARNING: failed to remove old session dirs: %s\n", err)
+					}
+					return nil
+				}})
+			}
+			if userMode && !podmanKeepId() {
+				phases = append(phases, startupPhase{name: "groups", run: func(ctx context.Context) error {
+					_, err := userObj.containerGroups()
+					return err
+				}})
+			}
+			if err := runPhases(phases...); err != nil {
+				removeSessionDir()
+				logger.Fatalf("Error: %s", err)
+			}
+			dockerRunArgs = append(dockerRunArgs, xauthArgs...)
+			if network, _ := cacherNetwork(); cacherUsed && network == dogiNetwork {
+				// --no-nethost without --network, join the apt-cacher
+				dockerRunArgs = append(dockerRunArgs, "--network="+dogiNetwork)
 			}
-
-			xauthCmd := fmt.Sprintf("%s nlist %s | sed -e 's/^..../ffff/' | %s -f %s nmerge -",
-				xauthCmdPath, displayEnv, xauthCmdPath, xauthfile.Name())
-			// logger.Println("xauth cmd:", xauthCmd)
-
-			createXauthCmd := exec.Command(bashCmdPath, "-c", xauthCmd)
-			check(createXauthCmd.Run())
 
 			workDirProvided() // initializes working directory
-			logger.Printf("workdir: %s\n", workDirPtr)
-			mountStrs := []string{fmt.Sprintf("--volume=%s:%s", workDirPtr, workDirPtr)}
+			logger.Printf("workdir: %s\n", opts.workDir)
+			mountStrs := []string{fmt.Sprintf("--volume=%s:%s", opts.workDir, opts.workDir)}
+			for _, vol := range opts.volumes {
+				mountStrs = append(mountStrs, fmt.Sprintf("--volume=%s", vol))
+			}
 
-			cidFile := fmt.Sprintf("%s/.%s%v.cid", tempDirPtr, appname, rand.Int63())
+			cidFile := filepath.Join(sessionDir, sessionCidFile)
 			mountStrs = append(mountStrs, fmt.Sprintf("--cidfile=%s", cidFile))
 			mountStrs = append(mountStrs, fmt.Sprintf("--volume=%s:%s", cidFile, cidFileContainer))
 
-			driCard1Device := "/dev/dri/card1"
-			if _, err := os.Stat(driCard1Device); !os.IsNotExist(err) {
-				logger.Printf("%s found, nvidia card? (3D might not work)\n",
-					driCard1Device)
+			if !opts.headless {
+				driCard1Device := "/dev/dri/card1"
+				if _, err := os.Stat(driCard1Device); !os.IsNotExist(err) {
+					logger.Printf("%s found, nvidia card? (3D might not work)\n",
+						driCard1Device)
+				}
+				dockerRunArgs = append(dockerRunArgs, "--device=/dev/dri")
 			}
 
 			dogiPath, err := os.Executable()
@@ -594,59 +849,60 @@ Examples:
 			logger.Printf("dogi path:%s", dogiPath)
 
 			dockerRunArgs = append(dockerRunArgs, []string{
-				fmt.Sprintf("--workdir=%s", workDirPtr),
-				"--volume=/tmp/.X11-unix:/tmp/.X11-unix",
-				"--env=XAUTHORITY=/.xauth",
+				fmt.Sprintf("--workdir=%s", opts.workDir),
 				// "--entrypoint=bash",
-				"--user=0",
 				// either none or both together
 				// ref: https://stackoverflow.com/a/35040140
-				// NOTE: QT_X11_NO_MITSHM – stops Qt form using the MIT-SHM X11 extension.
-				// "--env=QT_X11_NO_MITSHM=1",
-				// "--env=QT_GRAPHICSSYSTEM=native",
-				fmt.Sprintf("--env=DISPLAY=%s", displayEnv),
 				"--env=TERM",
-				"--device=/dev/dri",
 				// needed for realtime kernel
 				// https://stackoverflow.com/questions/47416870/checking-for-linux-capabilities-to-set-thread-priority
-				"--userns=host",
 				"--cap-add=SYS_NICE",
 				// TODO: actually this should be setup by tzdata package
 				// maybe it's better not to touch inside or set env var TZ?
 				// https://bugs.launchpad.net/ubuntu/+source/tzdata/+bug/1554806
-				fmt.Sprintf("--env=TZ=%s", timeZone()),
+				fmt.Sprintf("--env=TZ=%s", tz),
 				// "--volume=/etc/localtime:/etc/localtime:ro",
 				// "--volume=/etc/timezone:/etc/timezone:ro",
 			}...)
 			dockerRunArgs = append(dockerRunArgs, mountStrs...)
 
-			if gpusAllPtr {
+			if podmanKeepId() {
+				// podman maps the host user inside the container
+				// and adds it to /etc/passwd, no need to create it
+				logger.Println("rootless podman, using --userns=keep-id")
+				dockerRunArgs = append(dockerRunArgs,
+					"--userns=keep-id", "--group-add=keep-groups")
+			} else {
+				dockerRunArgs = append(dockerRunArgs, "--user=0", "--userns=host")
+			}
+
+			if opts.gpusAll {
 				dockerRunArgs = append(dockerRunArgs, "--gpus=all")
 			}
-			if nvidiaRuntimePtr {
+			if opts.nvidiaRuntime {
 				dockerRunArgs = append(dockerRunArgs, "--runtime=nvidia")
 			}
 
 			// if --name flag was provided
-			if contNamePtr != "" {
-				flag := fmt.Sprintf("--name=%s", contNamePtr)
-				logger.Printf("set container name: %s", contNamePtr)
+			if opts.contName != "" {
+				flag := fmt.Sprintf("--name=%s", opts.contName)
+				logger.Printf("set container name: %s", opts.contName)
 				dockerRunArgs = append(dockerRunArgs, flag)
 			}
 
-			if !noNethostPtr {
+			if !opts.noNethost {
 				logger.Println("adding --network=host")
 				dockerRunArgs = append(dockerRunArgs, "--network=host")
 			}
 
-			if !noPIDIPCHostPtr {
+			if !opts.noPIDIPCHost {
 				// useful for https://github.com/eProsima/Fast-DDS/issues/2956
 				logger.Println("add --pid=host --ipc=host, to disable use --no-pid-ipc-host")
 				dockerRunArgs = append(dockerRunArgs, "--pid=host")
 				dockerRunArgs = append(dockerRunArgs, "--ipc=host")
 			}
 
-			if privilegedPtr {
+			if opts.privileged {
 				logger.Println("adding --privileged")
 				dockerRunArgs = append(dockerRunArgs, "--privileged")
 			}
@@ -658,46 +914,28 @@ Examples:
 			// D-Bus not built with -rdynamic so unable to print a backtrace
 			dockerRunArgs = append(dockerRunArgs, "--security-opt=apparmor:unconfined")
 
-			if !noRMPtr {
+			if !opts.noRM {
 				dockerRunArgs = append(dockerRunArgs, "--rm")
 			}
 
-			if !imageExists(imageName) {
-				logger.Printf("Error: docker image or tag '%s' doesn't exist?", imageName)
-				logger.Printf("try using 'docker pull %s' first", imageName)
-				logger.Fatalf("check: docker image inspect %s", imageName)
-			}
-
-			distro := imageDistro(imageName) // empty if not supported
-
-			if aptCacherSupported(distro) {
-				if !noCacherPtr {
-					logger.Println("using apt-cacher, disable it with --no-cacher")
-					file := setAptCacher()
-					addCopyToContainerFile(file, "/etc/apt/apt.conf.d/01proxy")
-				} else {
-					logger.Println("disabling apt-cacher (--no-cacher=ON)")
-				}
-			} else {
-				logger.Println("image is not apt-based, disabling apt-cacher (--no-cacher=ON)")
-			}
-
 			// figure out the command to execute (image default or provided)
 			// logger.Println("cmd.ArgsLenAtDash():", cmd.ArgsLenAtDash())
 
 			var execCommand []string
-			if cmd.ArgsLenAtDash() == -1 {
+			if cmd.ArgsLenAtDash() == -1 && len(opts.command) > 0 {
+				logger.Printf("command from %s", opts.configPath)
+				execCommand = opts.command
+			} else if cmd.ArgsLenAtDash() == -1 {
 				// -- not provided means
 				// no command was provided, use image CMD
-				out, err := exec.Command("docker",
-					"inspect", "-f", "{{join .Config.Cmd \",\"}}", imageName).Output()
+				meta, err := imageMetadata(imageName)
 				if err != nil {
 					// TODO: fix this
 					logger.Printf("Error: docker inspect %s failed, image doesn't exist?", imageName)
 					logger.Fatalf("as a workaround, you can try executing this first: \ndocker pull %s", imageName)
 				}
 
-				execCommand = strings.Split(strings.TrimSpace(string(out[:])), ",")
+				execCommand = meta.Cmd
 				logger.Println("imageCmd: [", strings.Join(execCommand, ", "), "]")
 				if len(execCommand) == 0 {
 					logger.Printf("%s has no CMD command? please report this as an issue!\n",
@@ -711,42 +949,69 @@ Examples:
 			logger.Println("execCommand list:", execCommandStr)
 			entrypoint = execCommand
 
-			// create user script
-			userObj := userSingleton()
-
 			// mount cache vol
 			dockerRunArgs = append(dockerRunArgs,
-				fmt.Sprintf("--volume=%s_cache_vol:%s/.cache",
-					appname, userObj.HomeDir))
+				fmt.Sprintf("--volume=%s:%s/.cache",
+					cacheVolume, userObj.HomeDir))
 
-			cargoHomeContDir := cargoImage(imageName)
 			if cargoHomeContDir != "" {
 				logger.Printf("found CARGO_HOME:%s", cargoHomeContDir)
 				logger.Println("run cargo cache volume")
-				cargoCacheArg := fmt.Sprintf("--volume=%s_cargo-cache_vol:%s/registry",
-					appname, cargoHomeContDir)
+				cargoCacheArg := fmt.Sprintf("--volume=%s:%s/registry",
+					cargoCacheVolume, cargoHomeContDir)
 				logger.Printf("found CARGO_HOME:%s", cargoHomeContDir)
 				dockerRunArgs = append(dockerRunArgs, cargoCacheArg)
 			}
 
-			if !noUSBPtr {
+			if wayland != "" {
+				uid, gid := "0", "0"
+				if userMode {
+					uid, gid = userObj.Uid, userObj.Gid
+				}
+				dockerRunArgs = append(dockerRunArgs, waylandArgs(wayland, uid, gid)...)
+			}
+
+			if opts.audio {
+				dockerRunArgs = append(dockerRunArgs, audioArgs()...)
+			}
+
+			if !opts.noAgents {
+				dockerRunArgs = append(dockerRunArgs, sshAgentArgs()...)
+				// the home dir inside is the host one only in user mode
+				homeDir := ""
+				if userMode {
+					homeDir = userObj.HomeDir
+					runtimeDir := ""
+					if wayland != "" {
+						runtimeDir = containerRuntimeDir(userObj.Uid)
+					}
+					dockerRunArgs = append(dockerRunArgs, gpgAgentArgs(homeDir, runtimeDir)...)
+				}
+				dockerRunArgs = append(dockerRunArgs, gitConfigArgs(homeDir)...)
+			}
+
+			if !opts.noUSB {
 				logger.Printf("mount usb devices with correct permissions")
 				dockerRunArgs = append(dockerRunArgs,
 					"--volume=/dev/bus/usb:/dev/bus/usb")
-				dockerRunArgs = append(dockerRunArgs,
-					"--device-cgroup-rule=c 189:* rmw")
+				if rootlessPodman() {
+					logger.Println("device cgroup rules not supported with rootless podman, skipping them")
+				} else {
+					dockerRunArgs = append(dockerRunArgs,
+						"--device-cgroup-rule=c 189:* rmw")
+				}
 
 				// add commands to add rules to specific usb devices (as stated by https://stackoverflow.com/a/62758958)
-				if devRMWPtr != "" {
-					var indexes = strings.Split(devRMWPtr, ";")
+				if opts.devRMW != "" && !rootlessPodman() {
+					var indexes = strings.Split(opts.devRMW, ";")
 					for i := 0; i < len(indexes); i++ {
 						var s = "--device-cgroup-rule=c " + indexes[i] + ":* rmw"
 						dockerRunArgs = append(dockerRunArgs, s)
 					}
 				}
 				// add rules to mount specific usb devices
-				if devAccPtr != "" {
-					var indexes = strings.Split(devAccPtr, ";")
+				if opts.devAcc != "" {
+					var indexes = strings.Split(opts.devAcc, ";")
 					for i := 0; i < len(indexes); i++ {
 						var s = "--device=" + indexes[i]
 						dockerRunArgs = append(dockerRunArgs, s)
@@ -755,20 +1020,26 @@ Examples:
 
 			}
 
-			if !noUserPtr && userObj.Uid == "0" {
+			if !opts.noUser && userObj.Uid == "0" {
 				logger.Printf("⚡⚡ WARNING: super user detected, did you use sudo?\n")
 				logger.Printf("sudo dogi can only run with --no-user\n")
-			} else if !noUserPtr && userObj.Uid != "0" {
-				if distro == "" {
-					logger.Printf("WARNING: '%s' is not based on a supported distro?\n", imageName)
-					logger.Printf("ERROR: dogi only supports images based on these distros for now:\n")
-					for _, val := range supportedDistros() {
-						logger.Printf("  - %s\n", val)
-					}
-					logger.Printf("though you can run it as root with: --no-user\n")
-					logger.Fatalf("%s run --no-user %s\n", appname, imageName)
-				} else {
-					logger.Printf("supported distro image detected: %s\n", distro)
+			} else if !opts.noUser && podmanKeepId() {
+				// mount .ssh as read-only just in case
+				sshDir := fmt.Sprintf("%s/.ssh", userObj.HomeDir)
+				if _, err := os.Stat(sshDir); !os.IsNotExist(err) {
+					dockerRunArgs = append(dockerRunArgs,
+						fmt.Sprintf("--volume=%s:%s:ro", sshDir, sshDir))
+				}
+				// the home dir doesn't exist in the image, give the user a writable one
+				dockerRunArgs = append(dockerRunArgs,
+					fmt.Sprintf("--tmpfs=%s:rw,exec,uid=%s,gid=%s,mode=0755",
+						userObj.HomeDir, userObj.Uid, userObj.Gid),
+					fmt.Sprintf("--env=HOME=%s", userObj.HomeDir))
+			} else if !opts.noUser && userObj.Uid != "0" {
+				if distro == "" && !opts.noTools {
+					logger.Printf("'%s' is not based on a supported distro, won't install sudo\n", imageName)
+				} else if distro != "" {
+					logger.Printf("supported distro family detected: %s\n", distro)
 				}
 
 				// mount .ssh as read-only just in case
@@ -778,34 +1049,18 @@ Examples:
 						fmt.Sprintf("--volume=%s:%s:ro", sshDir, sshDir))
 				}
 
-				// TODO: createUser file won't be removed because
-				// process is replaced at Exec, is there a way?
-				// createUserFile, err := os.CreateTemp("",
-				// 	fmt.Sprintf(".%s*.sh", appname))
-				createUserFile, err := os.CreateTemp(tempDirPtr,
-					fmt.Sprintf(".%s*.sh", appname))
-				check(err)
-				logger.Println("create user script:", createUserFile.Name())
-				{
-					groupsCmd := userSingleton().createGroupsCmd()
-					err := template.Must(template.New("").Option("missingkey=error").Parse(assets.CreateUserTemplate)).Execute(createUserFile,
-						map[string]string{"username": userObj.Username,
-							"homedir":      userObj.HomeDir,
-							"uid":          userObj.Uid,
-							"ugid":         userObj.Gid,
-							"gnames":       groupsCmd.gnames,
-							"Name":         userObj.Name,
-							"createGroups": groupsCmd.cmd,
-						})
-					check(err)
-				}
-				const createUserScriptPath = "/" + appname + "_create_user.sh"
-				dockerRunArgs = append(dockerRunArgs,
-					fmt.Sprintf("--volume=%s:%s", createUserFile.Name(),
-						createUserScriptPath))
-				entrypoint = merge([]string{"bash", createUserScriptPath}, execCommand)
+				// dogi __init creates the user and runs the command as the user
+				entrypoint = initArgs(userObj, execCommand)
 			}
 
+			dockerRunArgs = append(dockerRunArgs, labelArgs(runLabels(imageName, userMode))...)
+
+			dockerRunFlags.checkDockerConflicts(runFlagConflicts())
+			dockerRunArgs = append(dockerRunArgs, dockerExtraArgs...)
+			if opts.buildImage {
+				checkUserImage()
+				imageName = userImage(imageName)
+			}
 			dockerRunArgs = append(dockerRunArgs, imageName)
 			// run command end
 			// ********************************************************
@@ -813,36 +1068,67 @@ Examples:
 
 			logger.Println("entrypoint:", entrypoint)
 
-			dockerCreateArgs := merge([]string{dockerCmd, "create"},
+			dockerCreateArgs := merge([]string{backend().Name(), "create"},
 				dockerRunArgs)
 			logger.Println("docker command: ", strings.Join(merge(mergeEscapeSpaces(dockerCreateArgs), entrypoint), " "))
 			dockerArgs := merge(dockerCreateArgs, entrypoint)
+			addCopyToContainerFile(dogiPath, dogiContainerPath)
+
+			if opts.dryRun {
+				printTimings()
+				addLaunchStep("create container", "cid=$("+shellQuote(dockerArgs...)+")")
+				srcpaths := []string{}
+				for key := range copyToContainerFiles {
+					srcpaths = append(srcpaths, key)
+				}
+				sort.Strings(srcpaths)
+				for _, key := range srcpaths {
+					addLaunchStep("copy "+key+" to container",
+						shellQuote(backend().Name(), "cp", "-aL", key)+
+							` "${cid}":`+shellQuote(copyToContainerFiles[key]))
+				}
+				addLaunchStep("attach to container", shellQuote(backend().Name(), "start", "-ai")+` "${cid}"`)
+				printLaunchSteps()
+				return
+			}
 
-			out, err := exec.Command(dockerArgs[0], dockerArgs[1:]...).CombinedOutput()
+			contId := ""
+			err = timed("create", func() (err error) {
+				contId, err = backend().Create(merge(dockerRunArgs, entrypoint))
+				return err
+			})
 			if err != nil {
-				fmt.Println(string(out))
-				syscall.Exit(1)
+				removeSessionDir()
+				logger.Fatalln(err)
 			}
-			contId := strings.TrimSpace(string(out))
 
-			addCopyToContainerFile(dogiPath, fmt.Sprintf("/usr/bin/%s", appname))
-			for key, val := range copyToContainerFiles {
-				copyToContainer(key, val, contId)
-			}
+			check(timed("copy", func() error {
+				for key, val := range copyToContainerFiles {
+					copyToContainer(key, val, contId)
+				}
+				return nil
+			}))
+			printTimings()
 
 			logger.Println("attach to container")
 			logger.Printf("docker start -ai %s\n", contId[:12])
 
-			if isSameDir(workDirPtr, userSingleton().HomeDir) {
-				fmt.Println(Red("WARNING: current directory is HOME") + " (read below) ⚡⚡")
-				fmt.Println("mounting home directory implies the container will use YOUR ~/.bashrc")
-				fmt.Println("the recommended usage is to launch dogi from your source directory")
+			if isSameDir(opts.workDir, userSingleton().HomeDir) {
+				out := logger.Writer()
+				fmt.Fprintln(out, Red("WARNING: current directory is HOME")+" (read below) ⚡⚡")
+				fmt.Fprintln(out, "mounting home directory implies the container will use YOUR ~/.bashrc")
+				fmt.Fprintln(out, "the recommended usage is to launch dogi from your source directory")
 			}
-			announceEnteringContainer()
-
-			// syscall exec is used to replace the current process
-			check(syscall.Exec(dockerBinPath(),
-				[]string{"docker", "start", "-ai", contId}, os.Environ()))
+			enterContainer(backend().StartAttachArgs(contId), "container", func(exitCode int) {
+				// the container id file is mounted in the container, keep
+				// the session dir while it can still be started
+				info, err := backend().InspectContainer(contId)
+				if err == nil && (info.State.Running || opts.noRM) {
+					return
+				}
+				logger.Printf("remove session dir %s\n", sessionDir)
+				removeSessionDir()
+			})
 
 		},
 	}
@@ -850,19 +1136,30 @@ Examples:
 
 func init() {
 	rootCmd.AddCommand(runCmd)
-	runCmd.Flags().BoolVar(&noUserPtr, "no-user", false, "don't use user inside container (run as root inside)")
-	runCmd.Flags().BoolVar(&nvidiaRuntimePtr, "runtime-nvidia", false, "add --runtime=nvidia")
-	runCmd.Flags().BoolVar(&gpusAllPtr, "gpus-all", false, "add --gpus=all")
-	runCmd.Flags().StringVar(&contNamePtr, "name", "", "change the container name")
-	runCmd.Flags().StringVar(&workDirPtr, "workdir", "", "working directory when launching the container, will be mounted inside")
-	runCmd.Flags().BoolVar(&privilegedPtr, "privileged", false, "add --privileged to docker run command")
-	runCmd.Flags().BoolVar(&noCacherPtr, "no-cacher", false, "don't launch apt-cacher container")
-	runCmd.Flags().BoolVar(&noRMPtr, "no-rm", false, "don't launch with --rm (container will exist after exiting)")
-	runCmd.Flags().BoolVar(&noUSBPtr, "no-usb", false, "don't mount usb devices")
-	runCmd.Flags().BoolVar(&noNethostPtr, "no-nethost", false, "don't launch with --network=host")
-	runCmd.Flags().StringVar(&devRMWPtr, "device-rmw", "", "add rmw rules to the following devices (as stated in https://stackoverflow.com/a/62758958). Format : <id_dev_a>;<id_dev_b>")
-	runCmd.Flags().StringVar(&devAccPtr, "device-access", "", "mount the following devices to container (through --device option). Format : <dev_name_a>;<dev_name_b>")
-	runCmd.Flags().StringVar(&tempDirPtr, "temp-dir", "", "temporary directory to use for dogi (default: $TMPDIR or /tmp, through empty command). Can be modified if there are access issues with this particular folder.")
-	runCmd.Flags().BoolVar(&noPIDIPCHostPtr, "no-pid-ipc-host", false, "don't launch with --pid=host --ipc=host.")
+	configCommands = append(configCommands, runCmd)
+	runCmd.Flags().BoolVar(&opts.noUser, "no-user", false, "don't use user inside container (run as root inside)")
+	runCmd.Flags().BoolVar(&opts.nvidiaRuntime, "runtime-nvidia", false, "add --runtime=nvidia")
+	runCmd.Flags().BoolVar(&opts.gpusAll, "gpus-all", false, "add --gpus=all")
+	runCmd.Flags().StringVar(&opts.contName, "name", "", "change the container name")
+	runCmd.Flags().StringVar(&opts.workDir, "workdir", "", "working directory when launching the container, will be mounted inside")
+	runCmd.Flags().BoolVar(&opts.privileged, "privileged", false, "add --privileged to docker run command")
+	runCmd.Flags().BoolVar(&opts.noCacher, "no-cacher", false, "don't use the package cache container (apt-cacher, for apt and dnf)")
+	runCmd.Flags().BoolVar(&opts.noRM, "no-rm", false, "don't launch with --rm (container will exist after exiting)")
+	runCmd.Flags().BoolVar(&opts.noUSB, "no-usb", false, "don't mount usb devices")
+	runCmd.Flags().BoolVar(&opts.noNethost, "no-nethost", false, "don't launch with --network=host")
+	runCmd.Flags().StringVar(&opts.devRMW, "device-rmw", "", "add rmw rules to the following devices (as stated in https://stackoverflow.com/a/62758958). Format : <id_dev_a>;<id_dev_b>")
+	runCmd.Flags().StringVar(&opts.devAcc, "device-access", "", "mount the following devices to container (through --device option). Format : <dev_name_a>;<dev_name_b>")
+	runCmd.Flags().StringVar(&opts.tempDir, "temp-dir", "", "temporary directory to use for dogi, each launch creates a private session dir in it (default: $TMPDIR or /tmp, through empty command). Can be modified if there are access issues with this particular folder.")
+	runCmd.Flags().BoolVar(&opts.noPIDIPCHost, "no-pid-ipc-host", false, "don't launch with --pid=host --ipc=host.")
+	runCmd.Flags().BoolVar(&opts.headless, "headless", false, "skip the display setup (X11, wayland and /dev/dri), automatic without DISPLAY or WAYLAND_DISPLAY")
+	runCmd.Flags().BoolVar(&opts.audio, "audio", false, "forward the pulseaudio/pipewire socket for sound")
+	runCmd.Flags().BoolVar(&opts.noAgents, "no-agents", false, "don't forward the ssh and gpg agents and the git config")
+	runCmd.Flags().BoolVar(&opts.buildImage, "build", false, "use an image with your user already set up, built if needed (see dogi build)")
+	runCmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "show the files and docker commands needed to launch the container, without executing them")
+	runCmd.Flags().BoolVar(&opts.printScript, "print", false, "print a standalone bash script that launches the container (implies --dry-run)")
+	runCmd.Flags().BoolVar(&opts.cacherOffline, "cacher-offline", false, "the package cache only serves the cached packages, without downloading (offline machines, see dogi cacher import)")
+	runCmd.Flags().BoolVar(&opts.noTools, "no-tools", false, "don't install sudo, vim, tzdata and bash-completion in the container when missing (faster launch)")
+	runCmd.Flags().BoolVar(&opts.timings, "timings", false, "print how long each startup phase took before entering the container")
+	runCmd.Flags().BoolVar(&opts.supervise, "supervise", false, "keep dogi running while in the container (instead of replacing it by docker) to forward signals, clean up and report the exit code")
 
 }
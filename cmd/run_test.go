@@ -0,0 +1,70 @@
+package cmd
+
+import "testing"
+
+func TestCacherProxyConfig(t *testing.T) {
+	const proxy = "http://dogi_apt-cacher_cont:3142"
+	tests := []struct {
+		name      string
+		distro    string
+		imageConf string
+		want      string
+	}{
+		{"apt", familyDebian, "",
+			"Acquire::http { Proxy \"" + proxy + "\"; };\n"},
+		{"dnf without config", familyFedora, "",
+			"[main]\nproxy=" + proxy + "\n"},
+		{"dnf main", familyFedora, "[main]\ngpgcheck=True\ninstallonly_limit=3\n",
+			"[main]\nproxy=" + proxy + "\ngpgcheck=True\ninstallonly_limit=3\n"},
+		{"dnf previous proxy", familyFedora, "[main]\nproxy = http://old:3128\ngpgcheck=1\n",
+			"[main]\nproxy=" + proxy + "\ngpgcheck=1\n"},
+		{"proxy of another section", familyFedora, "[other]\nproxy=http://other\n[main]\ngpgcheck=1\n",
+			"[other]\nproxy=http://other\n[main]\nproxy=" + proxy + "\ngpgcheck=1\n"},
+		{"yum without main", familyFedora, "# comment\n",
+			"# comment\n[main]\nproxy=" + proxy + "\n"},
+	}
+	for _, test := range tests {
+		if got := cacherProxyConfig(test.distro, test.imageConf, proxy); got != test.want {
+			t.Errorf("%s: cacherProxyConfig() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUseCacherPackageConf(t *testing.T) {
+	setTestBackend(t, &fakeBackend{images: map[string]map[string]string{
+		"fedora": {
+			"/etc/os-release":   "ID=fedora\n",
+			"/etc/dnf/dnf.conf": "[main]\ngpgcheck=True\n",
+		},
+		"centos7": {
+			"/etc/os-release": "ID=\"centos\"\nID_LIKE=\"rhel fedora\"\n",
+			"/etc/yum.conf":   "[main]\n",
+		},
+		// no package manager config, e.g. ubi-micro
+		"ubi-micro": {
+			"/usr/lib/os-release": "ID=\"rhel\"\nID_LIKE=\"fedora\"\n",
+		},
+		"ubuntu": {
+			"/etc/os-release": "ID=ubuntu\nID_LIKE=debian\n",
+		},
+	}})
+	tests := []struct {
+		image string
+		use   bool
+		conf  string
+	}{
+		{"fedora", true, "/etc/dnf/dnf.conf"},
+		{"centos7", true, "/etc/yum.conf"},
+		{"ubi-micro", false, ""},
+		{"ubuntu", true, "/etc/apt/apt.conf.d/01proxy"},
+	}
+	for _, test := range tests {
+		distro := imageDistro(test.image)
+		if use := useCacher(test.image, distro); use != test.use {
+			t.Errorf("useCacher(%s) = %t, want %t", test.image, use, test.use)
+		}
+		if path, _, _ := imagePackageConf(test.image, distro); path != test.conf {
+			t.Errorf("imagePackageConf(%s) = %q, want %q", test.image, path, test.conf)
+		}
+	}
+}
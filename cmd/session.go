@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+const gcExamples = `
+  - Delete the temp files of containers that don't exist anymore
+    (also done by every {{.appname}} run)
+
+    {{.appname}} gc
+`
+
+const (
+	sessionPattern = "." + appname + "_session_*"
+	// written by docker create --cidfile, it ties a session to its container
+	sessionCidFile = appname + ".cid"
+	// a session without container might still be launching
+	sessionGrace = 10 * time.Minute
+)
+
+var (
+	// sessionDir is the private temp dir of this launch, every temp
+	// file of dogi run goes there instead of the shared temp dir
+	sessionDir string
+	// locked until the exec into the container (close on exec), when
+	// the container id file already exists
+	sessionLock *os.File
+)
+
+// newSessionDir creates the 0700 session dir in --temp-dir
+func newSessionDir() error {
+	if opts.dryRun {
+		sessionDir = filepath.Join(opts.tempDir,
+			strings.Replace(sessionPattern, "*", fmt.Sprint(time.Now().UnixNano()), 1))
+		addLaunchStep("create session dir: "+sessionDir, shellQuote("mkdir", "-m", "0700", sessionDir))
+		return nil
+	}
+	dir, err := os.MkdirTemp(opts.tempDir, sessionPattern)
+	if err != nil {
+		return err
+	}
+	sessionDir = dir
+	logger.Printf("session dir: %s\n", sessionDir)
+	if sessionLock, err = os.Open(dir); err != nil {
+		return err
+	}
+	return unix.Flock(int(sessionLock.Fd()), unix.LOCK_SH)
+}
+
+// sessionLaunching is true while a dogi run is still using a session
+// dir without container
+func sessionLaunching(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	return unix.Flock(int(dir.Fd()), unix.LOCK_EX|unix.LOCK_NB) != nil
+}
+
+// removeSessionDir deletes the session dir when the launch failed
+func removeSessionDir() {
+	if sessionDir != "" && !opts.dryRun {
+		os.RemoveAll(sessionDir)
+	}
+}
+
+// ownedByUser skips the temp files of other users, they can't be removed
+func ownedByUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
+
+// gcSessions removes the session dirs (and the temp files of older
+// versions) in dir whose container doesn't exist anymore, it returns
+// how many were removed
+func gcSessions(ctx context.Context, dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	// without the containers nothing tied to one can be removed
+	containers, err := backend().Containers(true)
+	if err != nil {
+		return 0, err
+	}
+	exists := func(cid string) bool {
+		for _, cont := range containers {
+			if cont.ID == cid {
+				return true
+			}
+		}
+		return false
+	}
+	// the container of a session, empty if not created (yet)
+	sessionCid := func(path string) string {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(content))
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		name := entry.Name()
+		if !strings.HasPrefix(name, "."+appname) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !ownedByUser(info) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		recent := time.Since(info.ModTime()) < sessionGrace
+
+		cid := ""
+		switch {
+		case entry.IsDir() && strings.HasPrefix(name, strings.TrimSuffix(sessionPattern, "*")):
+			cid = sessionCid(filepath.Join(path, sessionCidFile))
+			if cid == "" && sessionLaunching(path) {
+				continue
+			}
+		case entry.IsDir():
+			continue
+		// loose temp files of older versions
+		case strings.HasSuffix(name, ".cid"):
+			cid = sessionCid(path)
+		case strings.HasSuffix(name, ".xauth"),
+			strings.HasPrefix(name, "."+appname+"_"+aptCacherName+"_"):
+		default:
+			continue
+		}
+		if (cid == "" && recent) || (cid != "" && exists(cid)) {
+			continue
+		}
+		logger.Printf("remove %s\n", path)
+		if err := os.RemoveAll(path); err != nil {
+			logger.Printf("WARNING: %s\n", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete the temp files of dogi containers that don't exist anymore",
+	Long: helpTemplate(`
+Every {{.appname}} run keeps its temp files (xauth cookie, package cache config, container id)
+in a private session dir, removed once its container doesn't exist anymore.
+---------------------------------------------
+
+Examples:
+
+{{.gcExamples}}
+---------------------------------------------
+`, map[string]string{"gcExamples": gcExamples}),
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if opts.tempDir == "" {
+			opts.tempDir = os.TempDir()
+		}
+		removed, err := gcSessions(context.Background(), opts.tempDir)
+		if err != nil {
+			logger.Fatalf("Error: %s", err)
+		}
+		fmt.Printf("deleted %d session dirs and temp files\n", removed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+	gcCmd.Flags().StringVar(&opts.tempDir, "temp-dir", "", "temporary directory used by dogi run (default: $TMPDIR or /tmp)")
+}
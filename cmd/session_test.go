@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestGCSessions(t *testing.T) {
+	setTestBackend(t, &fakeBackend{containers: []containerSummary{{ID: "running"}}})
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * sessionGrace)
+
+	// name to the container id of its session, "-" without cid file
+	sessions := map[string]string{
+		".dogi_session_running":   "running",
+		".dogi_session_gone":      "gone",
+		".dogi_session_recent":    "-",
+		".dogi_session_abandoned": "-",
+		".dogi_session_launching": "-",
+	}
+	for name, cid := range sessions {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if cid != "-" {
+			if err := os.WriteFile(filepath.Join(path, sessionCidFile), []byte(cid+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	files := map[string]string{
+		".dogi_old.xauth":   "",
+		".dogi_old.cid":     "gone",
+		".dogi_running.cid": "running",
+		".dogi_notes.txt":   "",
+		"other.xauth":       "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name := range sessions {
+		if name != ".dogi_session_recent" {
+			if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a dogi run still setting up this session
+	launching, err := os.Open(filepath.Join(dir, ".dogi_session_launching"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer launching.Close()
+	if err := unix.Flock(int(launching.Fd()), unix.LOCK_SH); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{".dogi_notes.txt", ".dogi_running.cid", ".dogi_session_launching",
+		".dogi_session_recent", ".dogi_session_running", "other.xauth"}
+	// the temp files of other users are skipped
+	if os.Getuid() == 0 {
+		other := filepath.Join(dir, ".dogi_session_other")
+		if err := os.Mkdir(other, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(other, 12345, 12345); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(other, old, old); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, ".dogi_session_other")
+		sort.Strings(want)
+	}
+
+	removed, err := gcSessions(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 4 {
+		t.Errorf("gcSessions() removed %d, want 4", removed)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after gcSessions() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// enterContainer runs the runtime cli command attached to the container
+// (args[0] is the cli name). By default dogi is replaced by it, with
+// --supervise it runs as a child and teardown runs after it exits,
+// then dogi exits with its exit code. what names the command in the
+// exit message, e.g. "container" or "exec".
+func enterContainer(args []string, what string, teardown func(exitCode int)) {
+	announceEnteringContainer()
+	if !opts.supervise {
+		// syscall exec is used to replace the current process
+		check(syscall.Exec(dockerBinPath(), args, os.Environ()))
+	}
+
+	start := time.Now()
+	exitCode, err := supervise(args)
+	if err != nil {
+		logger.Printf("Error: %s\n", err)
+	}
+	logger.Printf("%s exited with code %d after %s\n",
+		what, exitCode, time.Since(start).Round(time.Second))
+	if teardown != nil {
+		teardown(exitCode)
+	}
+	os.Exit(exitCode)
+}
+
+// supervise runs the cli as a child and returns its exit code, 128+n
+// if it was killed by the signal n like shells do
+func supervise(args []string) (int, error) {
+	child := exec.Command(dockerBinPath(), args[1:]...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// the child stays in the foreground process group of the terminal,
+	// so ctrl-c and window resizes (SIGWINCH) already reach it: dogi only
+	// has to survive them and forward the signals sent to itself. Without
+	// a terminal (e.g. kill -INT from a script) nothing else reaches it.
+	fromTerminal := term.IsTerminal(int(os.Stdin.Fd()))
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	if err := child.Start(); err != nil {
+		return 1, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if !fromTerminal || sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+					logger.Printf("forward %s to %s\n", sig, args[0])
+					_ = child.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := child.Wait()
+	if child.ProcessState == nil {
+		return 1, fmt.Errorf("%s: %w", args[0], err)
+	}
+	if status, ok := child.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return child.ProcessState.ExitCode(), nil
+}